@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	domain "lingo-backend/domain"
+	util "lingo-backend/utils"
+
+	"cloud.google.com/go/firestore"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const somethingWentWrong = "😕 Something went wrong, please try again later."
+
+type command struct {
+	description string
+	run         func(msg *tgbotapi.Message)
+}
+
+// commandOrder is the order commands are listed in /help
+var commandOrder = []string{"start", "setup", "cancel", "today", "join", "skip", "pair", "stats", "streak", "pause", "resume", "help"}
+
+func (b *Bot) commandSet() map[string]command {
+	return map[string]command{
+		"start":  {"Get a code to log into the app", b.start},
+		"setup":  {"Set your languages, level, timezone and availability", b.setup},
+		"cancel": {"Stop the current setup", b.cancel},
+		"today":  {"Show today's partner", b.today},
+		"join":   {"Confirm you're in for today's conversation", func(msg *tgbotapi.Message) { b.setParticipation(msg, true) }},
+		"skip":   {"Skip today's conversation", func(msg *tgbotapi.Message) { b.setParticipation(msg, false) }},
+		"pair":   {"Find a partner right now", b.pairNow},
+		"stats":  {"Show your attendance", b.stats},
+		"streak": {"Show your current streak", b.streak},
+		"pause":  {"Stop being paired until you resume", func(msg *tgbotapi.Message) { b.setPaused(msg, true) }},
+		"resume": {"Start being paired again", func(msg *tgbotapi.Message) { b.setPaused(msg, false) }},
+		"help":   {"List the commands", b.help},
+
+		// admin only, not listed in /help
+		"announcehere": {"Post the daily pairing in this group", b.announceHere},
+	}
+}
+
+func (b *Bot) handleCommand(msg *tgbotapi.Message) {
+	if msg.From == nil {
+		return // channel posts have no sender
+	}
+	// in groups /cmd@otherbot is meant for another bot
+	if _, to, found := strings.Cut(msg.CommandWithAt(), "@"); found && !strings.EqualFold(to, b.api.BotUsername()) {
+		return
+	}
+	cmd, ok := b.commands[msg.Command()]
+	if !ok {
+		// groups have other bots whose commands aren't ours to answer
+		if msg.Chat.IsPrivate() {
+			b.reply(msg, "Sorry, I don't know that command. Send /help to see what I can do.")
+		}
+		return
+	}
+	cmd.run(msg)
+}
+
+// announceHere makes the group the command was sent in the announcement group
+func (b *Bot) announceHere(msg *tgbotapi.Message) {
+	if !isBotAdmin(msg.From.ID) {
+		b.reply(msg, "Only admins can do that.")
+		return
+	}
+	if !msg.Chat.IsGroup() && !msg.Chat.IsSuperGroup() {
+		b.reply(msg, "Send this command in the group that should get the daily pairing.")
+		return
+	}
+	if _, err := b.pairs.SetAnnouncementGroup(msg.Chat.ID, msg.Chat.Title); err != nil {
+		log.Printf("failed to set announcement group %d: %v\n", msg.Chat.ID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	b.reply(msg, "📣 Done! The daily pairing will be posted here.")
+}
+
+func (b *Bot) help(msg *tgbotapi.Message) {
+	var sb strings.Builder
+	sb.WriteString("Here's what I can do:\n")
+	for _, name := range commandOrder {
+		fmt.Fprintf(&sb, "/%s - %s\n", name, b.commands[name].description)
+	}
+	b.reply(msg, sb.String())
+}
+
+func (b *Bot) today(msg *tgbotapi.Message) {
+	userID := msg.From.ID
+	pair, err := b.pairs.GetDailyPairs(userID)
+	if err != nil {
+		log.Printf("failed to load today's pair of %d: %v\n", userID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	if pair.ID == "" {
+		b.reply(msg, "You don't have a partner today. Send /pair to find one now.")
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("📅 Today's conversation:\n")
+	members := []struct {
+		id            int64
+		username      string
+		participating bool
+	}{
+		{pair.User1ID, pair.Username1, pair.User1Participating},
+		{pair.User2ID, pair.Username2, pair.User2Participating},
+		{pair.User3ID, pair.Username3, pair.User3Participating},
+	}
+	for _, m := range members {
+		if m.id == 0 {
+			continue
+		}
+		name := util.Mention(m.username)
+		if m.id == userID {
+			name = "You"
+		}
+		state := "⏳ not confirmed yet"
+		if m.participating {
+			state = "✅ in"
+		}
+		fmt.Fprintf(&sb, "%s - %s\n", name, state)
+	}
+	if deadline, err := time.Parse(time.RFC3339, pair.Deadline); err == nil {
+		loc, _ := time.LoadLocation(b.userTimezone(userID))
+		fmt.Fprintf(&sb, "Confirm before %s with /join or /skip.", deadline.In(loc).Format("15:04 MST"))
+	}
+	b.reply(msg, sb.String())
+}
+
+func (b *Bot) setParticipation(msg *tgbotapi.Message, participating bool) {
+	userID := msg.From.ID
+	pair, err := b.pairs.GetDailyPairs(userID)
+	if err != nil {
+		log.Printf("failed to load today's pair of %d: %v\n", userID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	if pair.ID == "" {
+		b.reply(msg, "You don't have a partner today. Send /pair to find one now.")
+		return
+	}
+
+	err = b.pairs.UpdatePairParticipation(pair.ID, userID, participating)
+	if err != nil && !errors.Is(err, domain.ErrParticipationClosed) && !errors.Is(err, domain.ErrNotInPair) &&
+		!errors.Is(err, domain.ErrPairRematched) {
+		log.Printf("failed to update participation of %d: %v\n", userID, err)
+	}
+	b.reply(msg, participationReply(err, participating))
+}
+
+// handleCallback answers the participation buttons of pair announcements
+func (b *Bot) handleCallback(cq *tgbotapi.CallbackQuery) {
+	var pairId string
+	var participating bool
+	switch {
+	case strings.HasPrefix(cq.Data, callbackJoin):
+		pairId, participating = strings.TrimPrefix(cq.Data, callbackJoin), true
+	case strings.HasPrefix(cq.Data, callbackSkip):
+		pairId, participating = strings.TrimPrefix(cq.Data, callbackSkip), false
+	default:
+		b.answerCallback(cq, "")
+		return
+	}
+
+	err := b.pairs.UpdatePairParticipation(pairId, cq.From.ID, participating)
+	switch {
+	case errors.Is(err, domain.ErrParticipationClosed), errors.Is(err, domain.ErrPairRematched):
+		b.announcer.ParticipationChanged(pairId) // drop the buttons
+	case errors.Is(err, domain.ErrNotInPair):
+	case err != nil:
+		log.Printf("failed to update participation of %d: %v\n", cq.From.ID, err)
+	}
+	b.answerCallback(cq, participationReply(err, participating))
+}
+
+func (b *Bot) answerCallback(cq *tgbotapi.CallbackQuery, text string) {
+	if _, err := b.api.Request(tgbotapi.NewCallback(cq.ID, text)); err != nil {
+		log.Printf("failed to answer callback of %d: %v\n", cq.From.ID, err)
+	}
+}
+
+func participationReply(err error, participating bool) string {
+	switch {
+	case errors.Is(err, domain.ErrParticipationClosed):
+		return "⌛ Too late, the deadline for today's conversation has passed."
+	case errors.Is(err, domain.ErrNotInPair):
+		return "You're not part of today's pair."
+	case errors.Is(err, domain.ErrPairRematched):
+		return "🔁 Too late to change, your partner has already been matched with someone else today."
+	case err != nil:
+		return somethingWentWrong
+	case participating:
+		return "🙌 Great, your partner knows you're in!"
+	default:
+		return "👍 Got it, we'll try to find your partner someone else today."
+	}
+}
+
+func (b *Bot) pairNow(msg *tgbotapi.Message) {
+	user := msg.From
+	profile, _ := b.profile(user.ID)
+	profileUrl, _ := profile["profileUrl"].(string)
+	prefs := domain.MatchPreferences{}
+	prefs.Level, _ = profile["level"].(string)
+	if languages, _ := profile["languages"].([]interface{}); len(languages) > 0 {
+		prefs.Language, _ = languages[0].(string)
+	}
+
+	resp, err := b.users.PairUser(user.ID, user.UserName, profileUrl, prefs)
+	if err != nil {
+		log.Printf("failed to pair %d: %v\n", user.ID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	if resp.Wait {
+		b.reply(msg, "⏳ You're in the queue, you'll be notified as soon as someone is found.")
+		return
+	}
+	b.reply(msg, "🎉 You've been matched! Open the app to start chatting.")
+}
+
+func (b *Bot) stats(msg *tgbotapi.Message) {
+	userID := msg.From.ID
+	profile, err := b.profile(userID)
+	if err != nil {
+		b.reply(msg, "I don't know you yet, send /start first.")
+		return
+	}
+	streak, err := b.streaks.CurrentStreak(context.Background(), userID)
+	if err != nil {
+		log.Printf("failed to load streak of %d: %v\n", userID, err)
+	}
+	b.reply(msg, fmt.Sprintf("📈 Sessions attended: %d\n❌ Missed: %d\n🔥 Streak: %d day(s)",
+		safeInt64(profile["attendance"]), safeInt64(profile["missCount"]), streak))
+}
+
+func (b *Bot) streak(msg *tgbotapi.Message) {
+	streak, err := b.streaks.CurrentStreak(context.Background(), msg.From.ID)
+	if err != nil {
+		log.Printf("failed to load streak of %d: %v\n", msg.From.ID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	if streak == 0 {
+		b.reply(msg, "You don't have a streak yet, /join today's conversation to start one!")
+		return
+	}
+	b.reply(msg, fmt.Sprintf("🔥 Your streak is %d day(s), keep it going!", streak))
+}
+
+// setPaused flags the profile so the daily rotation skips the user
+func (b *Bot) setPaused(msg *tgbotapi.Message, paused bool) {
+	userID := msg.From.ID
+	err := b.profiles.UpdateProfile(context.Background(), userID, []firestore.Update{
+		{Path: "paused", Value: paused},
+	})
+	if errors.Is(err, errProfileNotFound) {
+		b.reply(msg, "I don't know you yet, send /start first.")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to update paused flag of %d: %v\n", userID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	if paused {
+		b.reply(msg, "⏸ You won't be paired until you send /resume.")
+		return
+	}
+	b.reply(msg, "▶️ Welcome back! You'll be included in the next rotation.")
+}
+
+// profile reads the user's profile
+func (b *Bot) profile(userID int64) (map[string]interface{}, error) {
+	return b.profiles.Profile(context.Background(), userID)
+}
+
+func (b *Bot) userTimezone(userID int64) string {
+	profile, err := b.profile(userID)
+	if err != nil {
+		return util.DefaultTimezone()
+	}
+	tz, _ := profile["timezone"].(string)
+	return util.ResolveTimezone(tz)
+}
+
+func safeInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
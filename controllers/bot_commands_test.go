@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	domain "lingo-backend/domain"
+	util "lingo-backend/utils"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type stubPairRepo struct {
+	domain.PairRepository
+	pair      domain.Pair
+	updateErr error
+	updates   []bool // participation passed to UpdatePairParticipation
+}
+
+func (r *stubPairRepo) GetDailyPairs(userId int64) (domain.Pair, error) {
+	return r.pair, nil
+}
+
+func (r *stubPairRepo) UpdatePairParticipation(pairId string, userId int64, participating bool) error {
+	r.updates = append(r.updates, participating)
+	return r.updateErr
+}
+
+type stubUserRepo struct {
+	domain.UserRepository
+	wait  bool
+	prefs []domain.MatchPreferences
+}
+
+func (r *stubUserRepo) PairUser(userId int64, username string, profileUrl string, prefs domain.MatchPreferences) (util.PairResponse, error) {
+	r.prefs = append(r.prefs, prefs)
+	return util.PairResponse{Wait: r.wait}, nil
+}
+
+const testUserID = 1
+
+func todaysPair() domain.Pair {
+	return domain.Pair{
+		ID:                 "pair-1",
+		User1ID:            testUserID,
+		User2ID:            2,
+		Username1:          "user1",
+		Username2:          "partner",
+		User2Participating: true,
+		Deadline:           "2026-10-19T18:00:00Z",
+	}
+}
+
+// send runs a command and returns the bot's only reply
+func send(t *testing.T, b *Bot, client *FakeTelegramClient, text string) string {
+	t.Helper()
+	before := len(client.SentTexts())
+	b.handleUpdate(commandUpdate(testUserID, text))
+	texts := client.SentTexts()[before:]
+	if len(texts) != 1 {
+		t.Fatalf("%s: sent %q, want one reply", text, texts)
+	}
+	return texts[0]
+}
+
+func TestTodayCommand(t *testing.T) {
+	pairs := &stubPairRepo{pair: todaysPair()}
+	b, client, stores := newTestBot(t, pairs, &stubUserRepo{})
+	stores.profiles[testUserID] = map[string]interface{}{"timezone": "UTC"}
+
+	reply := send(t, b, client, "/today")
+	for _, want := range []string{"You - ⏳ not confirmed yet", "@partner - ✅ in", "Confirm before 18:00 UTC"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("reply %q doesn't contain %q", reply, want)
+		}
+	}
+
+	pairs.pair = domain.Pair{}
+	if reply := send(t, b, client, "/today"); !strings.Contains(reply, "don't have a partner today") {
+		t.Errorf("without a pair got %q", reply)
+	}
+}
+
+func TestJoinAndSkipCommands(t *testing.T) {
+	tests := []struct {
+		command   string
+		updateErr error
+		want      string
+	}{
+		{"/join", nil, "your partner knows you're in"},
+		{"/skip", nil, "find your partner someone else"},
+		{"/join", domain.ErrParticipationClosed, "deadline for today's conversation has passed"},
+		{"/skip", domain.ErrPairRematched, "already been matched with someone else"},
+		{"/join", errors.New("db down"), somethingWentWrong},
+	}
+	for _, tt := range tests {
+		pairs := &stubPairRepo{pair: todaysPair(), updateErr: tt.updateErr}
+		b, client, _ := newTestBot(t, pairs, &stubUserRepo{})
+
+		reply := send(t, b, client, tt.command)
+		if !strings.Contains(reply, tt.want) {
+			t.Errorf("%s with %v: got %q, want %q", tt.command, tt.updateErr, reply, tt.want)
+		}
+		if want := tt.command == "/join"; len(pairs.updates) != 1 || pairs.updates[0] != want {
+			t.Errorf("%s: participation updates %v, want [%v]", tt.command, pairs.updates, want)
+		}
+	}
+
+	pairs := &stubPairRepo{}
+	b, client, _ := newTestBot(t, pairs, &stubUserRepo{})
+	if reply := send(t, b, client, "/join"); !strings.Contains(reply, "don't have a partner today") {
+		t.Errorf("without a pair got %q", reply)
+	}
+	if len(pairs.updates) != 0 {
+		t.Errorf("participation was updated without a pair")
+	}
+}
+
+func TestPairCommand(t *testing.T) {
+	users := &stubUserRepo{}
+	b, client, stores := newTestBot(t, &stubPairRepo{}, users)
+	stores.profiles[testUserID] = map[string]interface{}{
+		"level":     "intermediate",
+		"languages": []interface{}{"Spanish", "German"},
+	}
+
+	if reply := send(t, b, client, "/pair"); !strings.Contains(reply, "You've been matched") {
+		t.Errorf("matched: got %q", reply)
+	}
+	if len(users.prefs) != 1 || users.prefs[0].Language != "Spanish" || users.prefs[0].Level != "intermediate" {
+		t.Errorf("paired with preferences %+v, want Spanish at intermediate level", users.prefs)
+	}
+
+	users.wait = true
+	if reply := send(t, b, client, "/pair"); !strings.Contains(reply, "You're in the queue") {
+		t.Errorf("waiting: got %q", reply)
+	}
+}
+
+func TestStatsCommand(t *testing.T) {
+	b, client, stores := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+
+	if reply := send(t, b, client, "/stats"); !strings.Contains(reply, "send /start first") {
+		t.Errorf("unknown user: got %q", reply)
+	}
+
+	stores.profiles[testUserID] = map[string]interface{}{"attendance": int64(12), "missCount": int64(3)}
+	stores.streaks[testUserID] = 4
+	reply := send(t, b, client, "/stats")
+	want := "📈 Sessions attended: 12\n❌ Missed: 3\n🔥 Streak: 4 day(s)"
+	if reply != want {
+		t.Errorf("got %q, want %q", reply, want)
+	}
+}
+
+func TestStreakCommand(t *testing.T) {
+	b, client, stores := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+
+	if reply := send(t, b, client, "/streak"); !strings.Contains(reply, "don't have a streak yet") {
+		t.Errorf("no streak: got %q", reply)
+	}
+
+	stores.streaks[testUserID] = 6
+	if reply := send(t, b, client, "/streak"); reply != "🔥 Your streak is 6 day(s), keep it going!" {
+		t.Errorf("got %q", reply)
+	}
+
+	stores.streakErr = errors.New("db down")
+	if reply := send(t, b, client, "/streak"); reply != somethingWentWrong {
+		t.Errorf("failing store: got %q", reply)
+	}
+}
+
+func TestPauseAndResumeCommands(t *testing.T) {
+	b, client, stores := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+
+	if reply := send(t, b, client, "/pause"); !strings.Contains(reply, "send /start first") {
+		t.Errorf("unknown user: got %q", reply)
+	}
+
+	stores.profiles[testUserID] = map[string]interface{}{}
+	if reply := send(t, b, client, "/pause"); !strings.Contains(reply, "won't be paired until you send /resume") {
+		t.Errorf("pause: got %q", reply)
+	}
+	if stores.profiles[testUserID]["paused"] != true {
+		t.Errorf("profile isn't paused")
+	}
+
+	if reply := send(t, b, client, "/resume"); !strings.Contains(reply, "Welcome back") {
+		t.Errorf("resume: got %q", reply)
+	}
+	if stores.profiles[testUserID]["paused"] != false {
+		t.Errorf("profile is still paused")
+	}
+}
+
+func TestHelpCommand(t *testing.T) {
+	b, client, _ := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+
+	reply := send(t, b, client, "/help")
+	for _, name := range commandOrder {
+		if !strings.Contains(reply, "/"+name+" - ") {
+			t.Errorf("help doesn't list /%s", name)
+		}
+	}
+	if strings.Contains(reply, "/announcehere") {
+		t.Errorf("help lists the admin only /announcehere")
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	b, client, _ := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+
+	if reply := send(t, b, client, "/dance"); !strings.Contains(reply, "I don't know that command") {
+		t.Errorf("got %q", reply)
+	}
+}
+
+func TestGroupCommands(t *testing.T) {
+	b, client, _ := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+	inGroup := func(text string) {
+		update := commandUpdate(testUserID, text)
+		update.Message.Chat = &tgbotapi.Chat{ID: -100, Type: "supergroup"}
+		b.handleUpdate(update)
+	}
+
+	inGroup("/dance")
+	inGroup("/help@otherbot")
+	if texts := client.SentTexts(); len(texts) != 0 {
+		t.Fatalf("answered commands that aren't ours: %q", texts)
+	}
+
+	inGroup("/help@Lingo_Bot")
+	if texts := client.SentTexts(); len(texts) != 1 || !strings.Contains(texts[0], "Here's what I can do") {
+		t.Errorf("sent %q, want the help", texts)
+	}
+}
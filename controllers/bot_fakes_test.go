@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	domain "lingo-backend/domain"
+	"lingo-backend/usecase"
+
+	"cloud.google.com/go/firestore"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// newTestBot is a bot wired to in-memory stores and a fake Telegram client
+func newTestBot(t *testing.T, pairs domain.PairRepository, users domain.UserRepository) (*Bot, *FakeTelegramClient, *fakeStores) {
+	t.Helper()
+	stores := &fakeStores{
+		profiles:      map[int64]map[string]interface{}{},
+		conversations: map[int64]fakeConversation{},
+		streaks:       map[int64]int{},
+	}
+	client := NewFakeTelegramClient()
+	b := &Bot{
+		pairs:         usecase.NewPairUsecase(pairs),
+		users:         usecase.NewUserUsecase(users),
+		otps:          stores,
+		profiles:      stores,
+		photos:        stores,
+		conversations: stores,
+		streaks:       stores,
+		api:           client,
+	}
+	b.commands = b.commandSet()
+	return b, client, stores
+}
+
+// commandUpdate is a command sent by the user in a private chat
+func commandUpdate(userID int64, text string) tgbotapi.Update {
+	length := len(text)
+	for i, r := range text {
+		if r == ' ' {
+			length = i
+			break
+		}
+	}
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		From:     &tgbotapi.User{ID: userID, UserName: fmt.Sprintf("user%d", userID)},
+		Chat:     &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:     text,
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}},
+	}}
+}
+
+type fakeConversation struct {
+	step    string
+	answers onboardingAnswers
+}
+
+// fakeStores keeps everything the bot stores in memory
+type fakeStores struct {
+	mu            sync.Mutex
+	otps          []domain.Otp
+	tokens        map[string]int64
+	profiles      map[int64]map[string]interface{}
+	upsertErr     error
+	uploads       []string // paths uploaded
+	destroyed     []string // URLs destroyed
+	conversations map[int64]fakeConversation
+	streaks       map[int64]int
+	streakErr     error
+}
+
+func (s *fakeStores) InsertOtp(otp domain.Otp) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.otps = append(s.otps, otp)
+	return nil
+}
+
+func (s *fakeStores) InsertLoginToken(userId int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = map[string]int64{}
+	}
+	token := fmt.Sprintf("token-%d-%d", userId, len(s.tokens))
+	s.tokens[token] = userId
+	return token, nil
+}
+
+func (s *fakeStores) Profile(ctx context.Context, userID int64) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile, ok := s.profiles[userID]
+	if !ok {
+		return nil, errProfileNotFound
+	}
+	copied := map[string]interface{}{}
+	for k, v := range profile {
+		copied[k] = v
+	}
+	return copied, nil
+}
+
+func (s *fakeStores) UpsertProfile(ctx context.Context, user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.upsertErr != nil {
+		return s.upsertErr
+	}
+	profile, ok := s.profiles[user.UserID]
+	if !ok {
+		s.profiles[user.UserID] = map[string]interface{}{
+			"userId":     user.UserID,
+			"username":   user.Username,
+			"profileUrl": user.ProfileURL,
+			"attendance": int64(0),
+			"missCount":  int64(0),
+		}
+		return nil
+	}
+	profile["username"] = user.Username
+	profile["profileUrl"] = user.ProfileURL
+	profile["inactive"] = false
+	return nil
+}
+
+func (s *fakeStores) UpdateProfile(ctx context.Context, userID int64, updates []firestore.Update) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profile, ok := s.profiles[userID]
+	if !ok {
+		return errProfileNotFound
+	}
+	for _, u := range updates {
+		// Firestore reads lists back as []interface{}
+		if list, ok := u.Value.([]string); ok {
+			values := make([]interface{}, len(list))
+			for i, v := range list {
+				values[i] = v
+			}
+			profile[u.Path] = values
+			continue
+		}
+		profile[u.Path] = u.Value
+	}
+	return nil
+}
+
+func (s *fakeStores) Upload(ctx context.Context, path string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads = append(s.uploads, path)
+	return fmt.Sprintf("https://photos.example/profile_%d.jpg", len(s.uploads)), nil
+}
+
+func (s *fakeStores) Destroy(ctx context.Context, url string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = append(s.destroyed, url)
+	return nil
+}
+
+func (s *fakeStores) Load(userID int64) (string, onboardingAnswers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.conversations[userID]
+	return c.step, c.answers, nil
+}
+
+func (s *fakeStores) Save(userID int64, step string, answers onboardingAnswers) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conversations[userID] = fakeConversation{step: step, answers: answers}
+	return nil
+}
+
+func (s *fakeStores) End(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conversations, userID)
+	return nil
+}
+
+func (s *fakeStores) CurrentStreak(ctx context.Context, userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.streaks[userID], s.streakErr
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	repo "lingo-backend/controllers/repository"
+	domain "lingo-backend/domain"
+	services "lingo-backend/service"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// The bot reaches storage only through these, so it can run offline with
+// in-memory implementations
+
+// OtpStore issues the codes and links users log into the app with
+type OtpStore interface {
+	InsertOtp(otp domain.Otp) error
+	InsertLoginToken(userId int64) (string, error)
+}
+
+// PhotoUploader hosts profile photos and returns their public URL
+type PhotoUploader interface {
+	Upload(ctx context.Context, path string) (string, error)
+	// Destroy removes a photo by the URL Upload returned
+	Destroy(ctx context.Context, url string) error
+}
+
+// ConversationStore keeps the step users are at in a multi-message
+// conversation such as onboarding
+type ConversationStore interface {
+	// Load returns the current step, empty when the user isn't in a conversation
+	Load(userID int64) (string, onboardingAnswers, error)
+	Save(userID int64, step string, answers onboardingAnswers) error
+	End(userID int64) error
+}
+
+// StreakCounter counts the days in a row a user attended
+type StreakCounter interface {
+	CurrentStreak(ctx context.Context, userID int64) (int, error)
+}
+
+type sqlOtpStore struct {
+	db *sql.DB
+}
+
+func (s sqlOtpStore) InsertOtp(otp domain.Otp) error {
+	return repo.InsertOtp(s.db, otp)
+}
+
+func (s sqlOtpStore) InsertLoginToken(userId int64) (string, error) {
+	return repo.InsertLoginToken(s.db, userId)
+}
+
+type sqlStreaks struct {
+	db *sql.DB
+}
+
+func (s sqlStreaks) CurrentStreak(ctx context.Context, userID int64) (int, error) {
+	return services.CurrentStreak(ctx, s.db, userID)
+}
+
+type sqlConversations struct {
+	db *sql.DB
+}
+
+func (s sqlConversations) Load(userID int64) (string, onboardingAnswers, error) {
+	var step string
+	var raw []byte
+	var answers onboardingAnswers
+	err := s.db.QueryRow(`SELECT step, answers FROM bot_conversations WHERE userid = $1`, userID).Scan(&step, &raw)
+	if err == sql.ErrNoRows {
+		return "", answers, nil
+	}
+	if err != nil {
+		return "", answers, err
+	}
+	if err := json.Unmarshal(raw, &answers); err != nil {
+		return "", answers, err
+	}
+	return step, answers, nil
+}
+
+func (s sqlConversations) Save(userID int64, step string, answers onboardingAnswers) error {
+	raw, err := json.Marshal(answers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO bot_conversations (userid, step, answers, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (userid) DO UPDATE SET step = EXCLUDED.step, answers = EXCLUDED.answers, updated_at = NOW()`,
+		userID, step, raw)
+	return err
+}
+
+func (s sqlConversations) End(userID int64) error {
+	_, err := s.db.Exec(`DELETE FROM bot_conversations WHERE userid = $1`, userID)
+	return err
+}
+
+// cloudinaryPhotos uploads to the Cloudinary account in CLOUD_NAME, API_KEY
+// and API_SECRET
+type cloudinaryPhotos struct{}
+
+func (cloudinaryPhotos) client() (*cloudinary.Cloudinary, error) {
+	return cloudinary.NewFromParams(os.Getenv("CLOUD_NAME"), os.Getenv("API_KEY"), os.Getenv("API_SECRET"))
+}
+
+func (c cloudinaryPhotos) Upload(ctx context.Context, path string) (string, error) {
+	cld, err := c.client()
+	if err != nil {
+		return "", err
+	}
+	resp, err := cld.Upload.Upload(ctx, path, uploader.UploadParams{})
+	if err != nil {
+		return "", err
+	}
+	return resp.SecureURL, nil
+}
+
+func (c cloudinaryPhotos) Destroy(ctx context.Context, url string) error {
+	publicID := getPublicIDFromURL(url)
+	if publicID == "" {
+		return nil
+	}
+	cld, err := c.client()
+	if err != nil {
+		return err
+	}
+	_, err = cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID})
+	return err
+}
+
+func getPublicIDFromURL(url string) string {
+	// Split the URL by "/" and get the last part
+	parts := strings.Split(url, "/")
+	if len(parts) == 0 {
+		return ""
+	}
+	// Get the filename: profile_1314364420_bikyig.jpg
+	filename := parts[len(parts)-1]
+
+	// Remove the file extension (.jpg, .png, etc.)
+	publicID := strings.TrimSuffix(filename, filepath.Ext(filename))
+	return publicID
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	util "lingo-backend/utils"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Bot modes, set with BOT_MODE
+const (
+	BotModePolling = "polling"
+	BotModeWebhook = "webhook"
+)
+
+// BotMode is how the bot receives updates. Polling only works on a single
+// instance, with several replicas use webhook mode.
+func BotMode() string {
+	if strings.EqualFold(os.Getenv("BOT_MODE"), BotModeWebhook) {
+		return BotModeWebhook
+	}
+	return BotModePolling
+}
+
+// WebhookPath is where Telegram posts updates. It is derived from the bot
+// token so it can't be guessed, and is the same on every replica.
+func WebhookPath() string {
+	return "/telegram/webhook/" + util.HashToken(os.Getenv("BOT_TOKEN"))[:32]
+}
+
+// webhookURL is the public address Telegram calls, BOT_WEBHOOK_URL is the
+// server's base URL
+func webhookURL() string {
+	return strings.TrimRight(os.Getenv("BOT_WEBHOOK_URL"), "/") + WebhookPath()
+}
+
+// setWebhook points Telegram at this deployment. secret_token isn't supported
+// by WebhookConfig in our tgbotapi version, so the request is built by hand.
+func (b *Bot) setWebhook() error {
+	if os.Getenv("BOT_WEBHOOK_URL") == "" || os.Getenv("BOT_WEBHOOK_SECRET") == "" {
+		return errors.New("BOT_WEBHOOK_URL and BOT_WEBHOOK_SECRET are required in webhook mode")
+	}
+	params := tgbotapi.Params{
+		"url":          webhookURL(),
+		"secret_token": os.Getenv("BOT_WEBHOOK_SECRET"),
+	}
+	if err := params.AddInterface("allowed_updates", []string{"message", "callback_query", "my_chat_member"}); err != nil {
+		return err
+	}
+	if _, err := b.api.MakeRequest("setWebhook", params); err != nil {
+		return err
+	}
+	log.Println("✅ Telegram webhook set")
+	return nil
+}
+
+// EnsureWebhook sets the webhook again if it went missing, e.g. when another
+// replica removed it while shutting down
+func (b *Bot) EnsureWebhook(ctx context.Context) error {
+	if BotMode() != BotModeWebhook || currentBot() == nil {
+		return nil
+	}
+	info, err := b.api.GetWebhookInfo()
+	if err != nil {
+		return err
+	}
+	if info.URL == webhookURL() {
+		return nil
+	}
+	return b.setWebhook()
+}
+
+// Stop stops receiving updates. In webhook mode there is nothing to stop: the
+// webhook is shared by every replica and stays set, and it is only deleted
+// when an instance starts polling.
+func (b *Bot) Stop() {
+	if currentBot() == nil || BotMode() != BotModePolling {
+		return
+	}
+	b.api.StopUpdates()
+}
+
+// ServeWebhook receives the updates Telegram pushes. Telegram retries on
+// anything but a 2xx, so every update is claimed by update_id first and
+// handled once even if it is delivered twice or to two replicas. The update
+// is handled before replying, so shutting the server down waits for it, and
+// a failed update gives its claim back for Telegram's retry.
+func (b *Bot) ServeWebhook(w http.ResponseWriter, r *http.Request) {
+	if currentBot() == nil {
+		util.WriteError(w, errBotNotConnected, http.StatusServiceUnavailable)
+		return
+	}
+	secret := os.Getenv("BOT_WEBHOOK_SECRET")
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		util.WriteError(w, errors.New("invalid secret token"), http.StatusUnauthorized)
+		return
+	}
+
+	var update tgbotapi.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	res, err := b.db.Exec(`
+		INSERT INTO telegram_updates (update_id) VALUES ($1)
+		ON CONFLICT (update_id) DO NOTHING`, update.UpdateID)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("Skipping duplicate Telegram update %d\n", update.UpdateID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err := b.handleWebhookUpdate(update); err != nil {
+		log.Printf("failed to handle Telegram update %d: %v\n", update.UpdateID, err)
+		if _, err := b.db.Exec(`DELETE FROM telegram_updates WHERE update_id = $1`, update.UpdateID); err != nil {
+			log.Printf("failed to release Telegram update %d: %v\n", update.UpdateID, err)
+		}
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleWebhookUpdate handles an update, turning a panic into an error so
+// one bad update doesn't take the server down
+func (b *Bot) handleWebhookUpdate(update tgbotapi.Update) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	b.handleUpdate(update)
+	return nil
+}
+
+// PruneUpdates forgets update IDs old enough that Telegram won't resend them
+func (b *Bot) PruneUpdates(ctx context.Context) error {
+	_, err := b.db.ExecContext(ctx, `DELETE FROM telegram_updates WHERE received_at < NOW() - INTERVAL '1 day'`)
+	return err
+}
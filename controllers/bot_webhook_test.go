@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHandleWebhookUpdateRecoversPanics(t *testing.T) {
+	b, _, _ := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+	b.commands["crash"] = command{run: func(msg *tgbotapi.Message) { panic("boom") }}
+
+	if err := b.handleWebhookUpdate(commandUpdate(testUserID, "/crash")); err == nil {
+		t.Error("a panicking update was reported as handled")
+	}
+	if err := b.handleWebhookUpdate(commandUpdate(testUserID, "/help")); err != nil {
+		t.Errorf("help failed: %v", err)
+	}
+}
+
+func TestStopKeepsWebhook(t *testing.T) {
+	t.Setenv("BOT_MODE", BotModeWebhook)
+	b, client, _ := newTestBot(t, &stubPairRepo{}, &stubUserRepo{})
+	useClient(t, client)
+	client.Webhook = tgbotapi.WebhookInfo{URL: "https://api.example/telegram/webhook/abc"}
+
+	b.Stop()
+	if client.Webhook.URL == "" {
+		t.Error("shutting down one replica deleted the shared webhook")
+	}
+}
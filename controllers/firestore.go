@@ -2,17 +2,20 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	util "lingo-backend/utils"
+
 	"cloud.google.com/go/firestore"
-	firebase "firebase.google.com/go/v4"
-	"google.golang.org/api/option"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+var errProfileNotFound = errors.New("profile not found")
+
 type User struct {
 	UserID            int64     `firestore:"userId"`
 	Username          string    `firestore:"username"`
@@ -20,30 +23,47 @@ type User struct {
 	MissCount         int64     `firestore:"missCount"`
 	Attendance        int64     `firestore:"attendance"`
 	ParticipatedCount int64     `firestore:"participatedCount"`
+	Timezone          string    `firestore:"timezone"`
+	Paused            bool      `firestore:"paused"`   // skipped by the daily rotation
+	Inactive          bool      `firestore:"inactive"` // blocked the bot or deleted their account
+	Languages         []string  `firestore:"languages"`
+	Level             string    `firestore:"level"`
+	Availability      []string  `firestore:"availability"`
 	CreatedAt         time.Time `firestore:"createdAt"`
 }
 
-func UpsertUserToFirebase(user User) error {
-	ctx := context.Background()
-	opt := option.WithCredentialsFile("lingo-firestore.json")
+// ProfileStore holds the users' profiles. Profile and UpdateProfile return
+// errProfileNotFound for users who never sent /start.
+type ProfileStore interface {
+	Profile(ctx context.Context, userID int64) (map[string]interface{}, error)
+	// UpsertProfile creates the profile, or refreshes the Telegram fields of an existing one
+	UpsertProfile(ctx context.Context, user User) error
+	UpdateProfile(ctx context.Context, userID int64, updates []firestore.Update) error
+}
 
-	app, err := firebase.NewApp(ctx, nil, opt)
-	if err != nil {
-		return err
-	}
+// firestoreProfiles keeps the profiles in the Firestore users collection
+type firestoreProfiles struct {
+	client *firestore.Client
+}
 
-	client, err := app.Firestore(ctx)
+func (p firestoreProfiles) Profile(ctx context.Context, userID int64) (map[string]interface{}, error) {
+	snap, err := p.client.Collection("users").Doc(fmt.Sprint(userID)).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, errProfileNotFound
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer client.Close()
+	return snap.Data(), nil
+}
 
-	docRef := client.Collection("users").Doc(fmt.Sprint(user.UserID))
+func (p firestoreProfiles) UpsertProfile(ctx context.Context, user User) error {
+	docRef := p.client.Collection("users").Doc(fmt.Sprint(user.UserID))
 
 	// Use a transaction to check existence and update or create accordingly
-	err = client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+	err := p.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
 		docSnap, err := tx.Get(docRef)
-		if err != nil && grpc.Code(err) != codes.NotFound {
+		if err != nil && status.Code(err) != codes.NotFound {
 			return err
 		}
 
@@ -53,16 +73,18 @@ func UpsertUserToFirebase(user User) error {
 			user.MissCount = 0
 			user.Attendance = 0
 			user.ParticipatedCount = 0
+			user.Timezone = util.ResolveTimezone(user.Timezone)
 			return tx.Set(docRef, user)
 		}
 
-		// User exists, update only username and profileUrl (keep createdAt)
+		// User exists, update only username and profileUrl (keep createdAt).
+		// Reaching us again means they unblocked the bot, so reactivate them.
 		return tx.Update(docRef, []firestore.Update{
 			{Path: "username", Value: user.Username},
 			{Path: "profileUrl", Value: user.ProfileURL},
+			{Path: "inactive", Value: false},
 		})
 	})
-
 	if err != nil {
 		return err
 	}
@@ -70,3 +92,11 @@ func UpsertUserToFirebase(user User) error {
 	log.Println("✅ User upserted in Firebase:", user.UserID)
 	return nil
 }
+
+func (p firestoreProfiles) UpdateProfile(ctx context.Context, userID int64, updates []firestore.Update) error {
+	_, err := p.client.Collection("users").Doc(fmt.Sprint(userID)).Update(ctx, updates)
+	if status.Code(err) == codes.NotFound {
+		return errProfileNotFound
+	}
+	return err
+}
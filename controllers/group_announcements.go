@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxMessageLength leaves some room under Telegram's 4096 character limit
+const maxMessageLength = 4000
+
+// groupMention mentions a member in an HTML message, users without a
+// username are mentioned by ID
+func groupMention(userID int64, username string) string {
+	if username != "" {
+		return "@" + html.EscapeString(username)
+	}
+	return fmt.Sprintf(`<a href="tg://user?id=%d">member</a>`, userID)
+}
+
+// groupAnnouncementLease is how long a replica may take to post the pairs
+// before another one resumes
+const groupAnnouncementLease = 5 * time.Minute
+
+// AnnounceToGroup posts today's pairs to the announcement group. It runs as a
+// job, each pair date is claimed in group_announcements so it is posted once
+// whichever replica ran the rotation. Long lists go out in several messages,
+// a failed run resumes after the last one that was posted.
+func (a *PairAnnouncer) AnnounceToGroup(ctx context.Context) error {
+	bot := currentBot()
+	if bot == nil {
+		return nil
+	}
+	var chatID int64
+	err := a.db.QueryRowContext(ctx, `SELECT chat_id FROM announcement_group WHERE id = 1`).Scan(&chatID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines, err := a.todaysPairLines(ctx)
+	if err != nil || len(lines) == 0 {
+		return err
+	}
+
+	var sent int
+	err = a.db.QueryRowContext(ctx, `
+		INSERT INTO group_announcements (date, chat_id, finished, claimed_until)
+		VALUES (CURRENT_DATE, $1, false, NOW() + $2 * INTERVAL '1 second')
+		ON CONFLICT (date) DO UPDATE SET claimed_until = EXCLUDED.claimed_until
+		WHERE NOT group_announcements.finished
+		  AND (group_announcements.claimed_until IS NULL OR group_announcements.claimed_until < NOW())
+		RETURNING sent_chunks`, chatID, groupAnnouncementLease.Seconds()).Scan(&sent)
+	if err == sql.ErrNoRows {
+		return nil // posted already, or another replica is at it
+	}
+	if err != nil {
+		return err
+	}
+
+	header := "📣 Today's conversation pairs\n\n"
+	footer := "\nConfirm with /join or /skip, here or in a private chat with me."
+	chunks := chunkLines(header, lines, footer)
+	for i := sent; i < len(chunks); i++ {
+		msg := tgbotapi.NewMessage(chatID, chunks[i])
+		msg.ParseMode = tgbotapi.ModeHTML
+		if _, err := bot.Send(msg); err != nil {
+			// let the next run continue from this chunk
+			_, _ = a.db.ExecContext(ctx, `UPDATE group_announcements SET claimed_until = NULL WHERE date = CURRENT_DATE`)
+			return fmt.Errorf("failed to post pairs to group %d: %w", chatID, err)
+		}
+		_, err := a.db.ExecContext(ctx, `UPDATE group_announcements SET sent_chunks = $1 WHERE date = CURRENT_DATE`, i+1)
+		if err != nil {
+			return fmt.Errorf("failed to record posted pairs: %w", err)
+		}
+	}
+	_, err = a.db.ExecContext(ctx, `
+		UPDATE group_announcements SET finished = true, claimed_until = NULL, sent_at = NOW()
+		WHERE date = CURRENT_DATE`)
+	return err
+}
+
+func (a *PairAnnouncer) todaysPairLines(ctx context.Context) ([]string, error) {
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT user1id, username1, user2id, username2, COALESCE(user3id, 0), COALESCE(username3, '')
+		FROM pairs
+		WHERE date = CURRENT_DATE
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var id1, id2, id3 int64
+		var name1, name2, name3 string
+		if err := rows.Scan(&id1, &name1, &id2, &name2, &id3, &name3); err != nil {
+			return nil, err
+		}
+		line := groupMention(id1, name1) + " & " + groupMention(id2, name2)
+		if id3 != 0 {
+			line = groupMention(id1, name1) + ", " + groupMention(id2, name2) + " & " + groupMention(id3, name3)
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s", len(lines)+1, line))
+	}
+	return lines, rows.Err()
+}
+
+// chunkLines splits a long list over several messages
+func chunkLines(header string, lines []string, footer string) []string {
+	var messages []string
+	var sb strings.Builder
+	sb.WriteString(header)
+	for _, line := range lines {
+		if sb.Len()+len(line)+1 > maxMessageLength {
+			messages = append(messages, sb.String())
+			sb.Reset()
+		}
+		sb.WriteString(line + "\n")
+	}
+	sb.WriteString(footer)
+	return append(messages, sb.String())
+}
+
+// isBotAdmin reports whether a Telegram user may configure the bot, admins
+// are listed in BOT_ADMIN_IDS (comma separated Telegram user IDs)
+func isBotAdmin(userID int64) bool {
+	for _, id := range strings.Split(os.Getenv("BOT_ADMIN_IDS"), ",") {
+		if parsed, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil && parsed == userID {
+			return true
+		}
+	}
+	return false
+}
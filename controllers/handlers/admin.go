@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	util "lingo-backend/utils"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// RequireAdmin guards admin-only endpoints with the ADMIN_TOKEN env var,
+// sent either as a bearer token or in the X-Admin-Token header.
+// When ADMIN_TOKEN isn't set every admin request is refused.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := os.Getenv("ADMIN_TOKEN")
+		provided := r.Header.Get("X-Admin-Token")
+		if provided == "" {
+			provided = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(provided)) != 1 {
+			util.WriteError(w, errors.New("admin access required"), http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+type SessionValidator interface {
+	ValidateSession(token string) (int64, error)
+}
+
+// sessionToken reads the session token from the Authorization header, or from
+// ?token= for clients that can't set headers (WebSocket, EventSource)
+func sessionToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
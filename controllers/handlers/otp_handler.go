@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"lingo-backend/domain"
 	usecase "lingo-backend/usecase"
 	util "lingo-backend/utils"
@@ -31,13 +32,52 @@ func (h *OtpHandler) CheckOtp(w http.ResponseWriter, r *http.Request) {
 		util.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
-	result, err := h.usecase.CheckOtp(payload.Username, payload.Otp)
+	if payload.UserID == 0 && payload.Username == "" {
+		util.WriteError(w, errors.New("userId or username is required"), http.StatusBadRequest)
+		return
+	}
+	result, err := h.usecase.CheckOtp(payload.UserID, payload.Username, payload.Otp)
+	if errors.Is(err, domain.ErrOtpLocked) {
+		util.WriteError(w, err, http.StatusTooManyRequests)
+		return
+	}
 	if err != nil {
 		util.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
-	util.WriteJSON(w, http.StatusOK, map[string]*domain.User{"user": result})
+	token, err := h.usecase.CreateSession(result.ID)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]interface{}{"user": result, "token": token})
+
+}
 
+// ExchangeLoginToken logs in with the token from the bot's login link
+func (h *OtpHandler) ExchangeLoginToken(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	result, err := h.usecase.ExchangeLoginToken(payload.Token)
+	if errors.Is(err, domain.ErrInvalidLogin) || errors.Is(err, domain.ErrUnknownUser) {
+		util.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	token, err := h.usecase.CreateSession(result.ID)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]interface{}{"user": result, "token": token})
 }
 
 func (h *OtpHandler) WakeUpRender(w http.ResponseWriter, r *http.Request) {
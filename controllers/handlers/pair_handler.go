@@ -3,7 +3,9 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"lingo-backend/domain"
 	"lingo-backend/usecase"
 	util "lingo-backend/utils"
 	"log"
@@ -20,12 +22,14 @@ import (
 )
 
 type PairHandler struct {
-	usecase usecase.PairUsecase
+	usecase  usecase.PairUsecase
+	sessions SessionValidator
 }
 
-func NewPairHandler(usecase usecase.PairUsecase) *PairHandler {
+func NewPairHandler(usecase usecase.PairUsecase, sessions SessionValidator) *PairHandler {
 	return &PairHandler{
-		usecase: usecase,
+		usecase:  usecase,
+		sessions: sessions,
 	}
 }
 
@@ -42,7 +46,7 @@ func (p *PairHandler) GetDailyPairs(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error fetching daily pairs", http.StatusInternalServerError)
 		return
 	}
-	if pair.ID == 0 {
+	if pair.ID == "" {
 		http.Error(w, "No pairs found for today", http.StatusNotFound)
 		return
 	}
@@ -102,6 +106,14 @@ func (p *PairHandler) UpdatePairParticipation(w http.ResponseWriter, r *http.Req
 		return
 	}
 	err = p.usecase.UpdatePairParticipation(payload.PairID, payload.UserID, payload.Participating)
+	if errors.Is(err, domain.ErrParticipationClosed) || errors.Is(err, domain.ErrPairRematched) {
+		util.WriteError(w, err, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, domain.ErrNotInPair) {
+		util.WriteError(w, err, http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		util.WriteError(w, err, http.StatusBadRequest)
 		return
@@ -109,6 +121,68 @@ func (p *PairHandler) UpdatePairParticipation(w http.ResponseWriter, r *http.Req
 	util.WriteJSON(w, http.StatusOK, "Updated Successfully!")
 }
 
+func (p *PairHandler) SetParticipationDeadline(w http.ResponseWriter, r *http.Request) {
+	type Payload struct {
+		Date     string    `json:"date"`     // YYYY-MM-DD
+		Deadline time.Time `json:"deadline"` // RFC3339
+	}
+	var payload Payload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if _, err := time.Parse("2006-01-02", payload.Date); err != nil {
+		util.WriteError(w, errors.New("date must be in YYYY-MM-DD format"), http.StatusBadRequest)
+		return
+	}
+	if payload.Deadline.IsZero() {
+		util.WriteError(w, errors.New("deadline is required"), http.StatusBadRequest)
+		return
+	}
+	updated, err := p.usecase.SetParticipationDeadline(payload.Date, payload.Deadline)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]int64{"updatedPairs": updated})
+}
+
+// ConfirmSession records the logged in member's reports on who showed up
+func (p *PairHandler) ConfirmSession(w http.ResponseWriter, r *http.Request) {
+	reporterId, err := p.sessions.ValidateSession(sessionToken(r))
+	if err != nil {
+		util.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	type Payload struct {
+		PairID        string                       `json:"pairId"`
+		Confirmations []domain.SessionConfirmation `json:"confirmations"`
+	}
+	var payload Payload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if payload.PairID == "" || len(payload.Confirmations) == 0 {
+		util.WriteError(w, errors.New("pairId and confirmations are required"), http.StatusBadRequest)
+		return
+	}
+	err = p.usecase.ConfirmSession(payload.PairID, reporterId, payload.Confirmations)
+	if errors.Is(err, domain.ErrNotInPair) {
+		util.WriteError(w, err, http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, domain.ErrSessionNotHeld) {
+		util.WriteError(w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]string{"message": "Confirmation saved"})
+}
+
 type User struct {
 	ID       int64
 	Username string
@@ -166,3 +240,45 @@ func saveChatroomToRealtimeDB(app *firebase.App, yes []User, isSpecialGroup bool
 	log.Println("Chatroom saved to Realtime DB:", roomID)
 	return nil
 }
+
+func (p *PairHandler) GetAnnouncementGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := p.usecase.GetAnnouncementGroup()
+	if errors.Is(err, domain.ErrNoAnnouncementGroup) {
+		util.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, group)
+}
+
+func (p *PairHandler) SetAnnouncementGroup(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		ChatID int64  `json:"chatId"`
+		Title  string `json:"title"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if payload.ChatID == 0 {
+		util.WriteError(w, errors.New("chatId is required"), http.StatusBadRequest)
+		return
+	}
+	group, err := p.usecase.SetAnnouncementGroup(payload.ChatID, payload.Title)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, group)
+}
+
+func (p *PairHandler) ClearAnnouncementGroup(w http.ResponseWriter, r *http.Request) {
+	if err := p.usecase.ClearAnnouncementGroup(); err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]string{"message": "Announcement group removed"})
+}
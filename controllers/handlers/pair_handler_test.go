@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"lingo-backend/domain"
+	"lingo-backend/usecase"
+)
+
+// stubSessions accepts the tokens it holds
+type stubSessions map[string]int64
+
+func (s stubSessions) ValidateSession(token string) (int64, error) {
+	if userId, ok := s[token]; ok {
+		return userId, nil
+	}
+	return 0, domain.ErrInvalidSession
+}
+
+type confirmRepo struct {
+	domain.PairRepository
+	reporters []int64
+	err       error
+}
+
+func (r *confirmRepo) ConfirmSession(pairId string, reporterId int64, reports []domain.SessionConfirmation) error {
+	r.reporters = append(r.reporters, reporterId)
+	return r.err
+}
+
+func TestConfirmSession(t *testing.T) {
+	const body = `{"pairId": "pair-1", "userId": 2, "confirmations": [{"userId": 2, "showedUp": false}]}`
+	tests := []struct {
+		name       string
+		token      string
+		repoErr    error
+		wantStatus int
+		wantCalls  int
+	}{
+		{"no session", "", nil, http.StatusUnauthorized, 0},
+		{"unknown session", "forged", nil, http.StatusUnauthorized, 0},
+		{"member", "token-1", nil, http.StatusOK, 1},
+		{"not a member", "token-1", domain.ErrNotInPair, http.StatusForbidden, 1},
+		{"before the session", "token-1", domain.ErrSessionNotHeld, http.StatusConflict, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &confirmRepo{err: tt.repoErr}
+			h := NewPairHandler(*usecase.NewPairUsecase(repo), stubSessions{"token-1": 1})
+
+			req := httptest.NewRequest(http.MethodPost, "/pair/confirm", strings.NewReader(body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.ConfirmSession(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if len(repo.reporters) != tt.wantCalls {
+				t.Fatalf("ConfirmSession called %d times, want %d", len(repo.reporters), tt.wantCalls)
+			}
+			// the userId in the body is ignored, the reporter is whoever is logged in
+			if tt.wantCalls > 0 && repo.reporters[0] != 1 {
+				t.Errorf("reported as %d, want the session's user 1", repo.reporters[0])
+			}
+		})
+	}
+}
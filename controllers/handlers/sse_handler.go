@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lingo-backend/domain"
+	util "lingo-backend/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const sseHeartbeatPeriod = 25 * time.Second
+
+// StreamEvents is the Server-Sent Events fallback for clients whose proxies
+// break WebSocket upgrades. It carries the same events, and a reconnecting
+// client gets everything after its Last-Event-ID replayed from the event log.
+func (h *UserHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	sessionUser, err := h.sessions.ValidateSession(sessionToken(r))
+	if err != nil {
+		util.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+	if sessionUser != userId {
+		util.WriteError(w, errors.New("cannot stream another user's events"), http.StatusForbidden)
+		return
+	}
+
+	// EventSource sends the header on reconnect, ?lastEventId= covers the first connect
+	lastId := r.Header.Get("Last-Event-ID")
+	if lastId == "" {
+		lastId = r.URL.Query().Get("lastEventId")
+	}
+	lastSent, _ := strconv.ParseInt(lastId, 10, 64)
+
+	// Subscribe before reading the backlog so nothing slips in between
+	sub := h.hub.Subscribe(userId)
+	defer sub.Close()
+
+	var backlog []domain.Event
+	if lastSent > 0 {
+		backlog, err = h.usecase.GetEvents(userId, lastSent)
+		if err != nil {
+			util.WriteError(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+
+	for _, event := range backlog {
+		if err := writeSSE(w, event); err != nil {
+			return
+		}
+		lastSent = event.ID
+	}
+	rc.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatPeriod)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events:
+			if !ok {
+				return
+			}
+			if event.ID != 0 && event.ID <= lastSent {
+				continue // already replayed from the backlog
+			}
+			if err := writeSSE(w, event); err != nil {
+				return
+			}
+			if event.ID != 0 {
+				lastSent = event.ID
+			}
+			rc.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, event domain.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.ID != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.ID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
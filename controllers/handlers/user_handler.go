@@ -2,10 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"lingo-backend/domain"
+	services "lingo-backend/service"
 	"lingo-backend/usecase"
 	util "lingo-backend/utils"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/gorilla/mux"
@@ -13,7 +17,9 @@ import (
 )
 
 type UserHandler struct {
-	usecase usecase.UserUsecase
+	usecase  usecase.UserUsecase
+	hub      *services.Hub
+	sessions SessionValidator
 }
 
 var upgrader = websocket.Upgrader{
@@ -22,13 +28,16 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func NewUserHandler(usecase usecase.UserUsecase) *UserHandler {
+func NewUserHandler(usecase usecase.UserUsecase, hub *services.Hub, sessions SessionValidator) *UserHandler {
 	return &UserHandler{
-		usecase: usecase,
+		usecase:  usecase,
+		hub:      hub,
+		sessions: sessions,
 	}
 }
 func (h *UserHandler) FillAttendance(w http.ResponseWriter, r *http.Request) {
 	type UserIdsRequest struct {
+		PairId  string  `json:"pairId"`
 		UserIds []int64 `json:"userIds"`
 	}
 
@@ -37,7 +46,11 @@ func (h *UserHandler) FillAttendance(w http.ResponseWriter, r *http.Request) {
 		util.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
-	if err := h.usecase.FillAttendance(userIds.UserIds); err != nil {
+	if userIds.PairId == "" {
+		util.WriteError(w, errors.New("pairId is required"), http.StatusBadRequest)
+		return
+	}
+	if err := h.usecase.FillAttendance(userIds.PairId, userIds.UserIds); err != nil {
 		util.WriteError(w, err, http.StatusInternalServerError)
 		return
 	}
@@ -46,9 +59,10 @@ func (h *UserHandler) FillAttendance(w http.ResponseWriter, r *http.Request) {
 }
 func (h *UserHandler) PairUser(w http.ResponseWriter, r *http.Request) {
 	type PairUserRequest struct {
-		UserId     int64  `json:"userId"`
-		Username   string `json:"username"`
-		ProfileUrl string `json:"profileUrl"`
+		UserId      int64                   `json:"userId"`
+		Username    string                  `json:"username"`
+		ProfileUrl  string                  `json:"profileUrl"`
+		Preferences domain.MatchPreferences `json:"preferences"`
 	}
 
 	var pairUserReq PairUserRequest
@@ -56,7 +70,7 @@ func (h *UserHandler) PairUser(w http.ResponseWriter, r *http.Request) {
 		util.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
-	resp, err := h.usecase.PairUser(pairUserReq.UserId, pairUserReq.Username, pairUserReq.ProfileUrl)
+	resp, err := h.usecase.PairUser(pairUserReq.UserId, pairUserReq.Username, pairUserReq.ProfileUrl, pairUserReq.Preferences)
 	if err != nil {
 		util.WriteError(w, err, http.StatusInternalServerError)
 		return
@@ -67,6 +81,40 @@ func (h *UserHandler) PairUser(w http.ResponseWriter, r *http.Request) {
 	}
 	util.WriteJSON(w, http.StatusOK, map[string]bool{"wait": false})
 }
+func (h *UserHandler) CancelPairRequest(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		UserId int64 `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	err := h.usecase.CancelPairRequest(payload.UserId)
+	if errors.Is(err, domain.ErrNotWaiting) {
+		util.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]string{"message": "Pair request cancelled"})
+}
+
+func (h *UserHandler) GetWaitlistStatus(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	status, err := h.usecase.GetWaitlistStatus(userId)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, status)
+}
+
 func (h *UserHandler) GetNotifications(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	value := vars["userId"]
@@ -76,7 +124,9 @@ func (h *UserHandler) GetNotifications(w http.ResponseWriter, r *http.Request) {
 		util.WriteError(w, err, http.StatusBadRequest)
 		return
 	}
-	notifications, err := h.usecase.GetNotifications(userId)
+	cursor, _ := strconv.ParseInt(r.URL.Query().Get("cursor"), 10, 64)
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	notifications, err := h.usecase.GetNotifications(userId, cursor, limit)
 	if err != nil {
 		util.WriteError(w, err, http.StatusInternalServerError)
 		return
@@ -103,6 +153,256 @@ func (h *UserHandler) SeenNotification(w http.ResponseWriter, r *http.Request) {
 	util.WriteJSON(w, http.StatusOK, map[string]string{"message": "Notifications marked as seen"})
 }
 
+func (h *UserHandler) GetUnreadCount(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	count, err := h.usecase.GetUnreadCount(userId)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]int64{"unreadCount": count})
+}
+
+func (h *UserHandler) MarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	userId, err := strconv.ParseInt(vars["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	notificationId, err := strconv.ParseInt(vars["notificationId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	err = h.usecase.MarkNotificationRead(userId, notificationId)
+	if errors.Is(err, domain.ErrNotificationNotFound) {
+		util.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]string{"message": "Notification marked as seen"})
+}
+
+// MarkNotificationsRead marks everything as read, or with ?before=<id> only
+// the notifications up to and including that one
+func (h *UserHandler) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	before := int64(math.MaxInt64)
+	if value := r.URL.Query().Get("before"); value != "" {
+		if before, err = strconv.ParseInt(value, 10, 64); err != nil {
+			util.WriteError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	updated, err := h.usecase.MarkNotificationsReadBefore(userId, before)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]int64{"updated": updated})
+}
+
+func (h *UserHandler) GetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	settings, err := h.usecase.GetNotificationSettings(userId)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, settings)
+}
+
+func (h *UserHandler) UpdateNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	// fields left out keep their current value, "quietHours": null turns them off
+	var changes struct {
+		InApp      *bool           `json:"inApp"`
+		WebSocket  *bool           `json:"websocket"`
+		Telegram   *bool           `json:"telegram"`
+		QuietHours json.RawMessage `json:"quietHours"`
+		Timezone   *string         `json:"timezone"`
+		Digest     *string         `json:"digest"`
+		Types      []struct {
+			Type     string `json:"type"`
+			Enabled  *bool  `json:"enabled"`
+			Telegram *bool  `json:"telegram"`
+		} `json:"types"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	settings, err := h.usecase.GetNotificationSettings(userId)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if changes.InApp != nil {
+		settings.InApp = *changes.InApp
+	}
+	if changes.WebSocket != nil {
+		settings.WebSocket = *changes.WebSocket
+	}
+	if changes.Telegram != nil {
+		settings.Telegram = *changes.Telegram
+	}
+	if len(changes.QuietHours) > 0 {
+		settings.QuietHours = nil
+		if err := json.Unmarshal(changes.QuietHours, &settings.QuietHours); err != nil {
+			util.WriteError(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	if changes.Timezone != nil {
+		settings.Timezone = *changes.Timezone
+	}
+	if changes.Digest != nil {
+		settings.Digest = *changes.Digest
+	}
+	for _, change := range changes.Types {
+		i := slices.IndexFunc(settings.Types, func(p domain.NotificationPreference) bool { return p.Type == change.Type })
+		if i < 0 {
+			// unknown types are rejected by validation
+			settings.Types = append(settings.Types, domain.NotificationPreference{Type: change.Type})
+			i = len(settings.Types) - 1
+		}
+		if change.Enabled != nil {
+			settings.Types[i].Enabled = *change.Enabled
+		}
+		if change.Telegram != nil {
+			settings.Types[i].Telegram = *change.Telegram
+		}
+	}
+	updated, err := h.usecase.UpdateNotificationSettings(userId, settings)
+	if errors.Is(err, domain.ErrInvalidNotificationSettings) || errors.Is(err, domain.ErrUnknownNotificationType) {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (h *UserHandler) ResetNotificationSettings(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	settings, err := h.usecase.ResetNotificationSettings(userId)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, settings)
+}
+
+func (h *UserHandler) GetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	prefs, err := h.usecase.GetNotificationPreferences(userId)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, prefs)
+}
+
+func (h *UserHandler) UpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(mux.Vars(r)["userId"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	// fields left out keep their current value
+	var changes []struct {
+		Type     string `json:"type"`
+		Enabled  *bool  `json:"enabled"`
+		Telegram *bool  `json:"telegram"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	current, err := h.usecase.GetNotificationPreferences(userId)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	prefs := make([]domain.NotificationPreference, 0, len(changes))
+	for _, change := range changes {
+		pref := domain.NotificationPreference{Type: change.Type}
+		for _, c := range current {
+			if c.Type == change.Type {
+				pref = c
+			}
+		}
+		if change.Enabled != nil {
+			pref.Enabled = *change.Enabled
+		}
+		if change.Telegram != nil {
+			pref.Telegram = *change.Telegram
+		}
+		prefs = append(prefs, pref)
+	}
+	updated, err := h.usecase.UpdateNotificationPreferences(userId, prefs)
+	if errors.Is(err, domain.ErrUnknownNotificationType) {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, updated)
+}
+
+func (h *UserHandler) BroadcastNotification(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Message string                 `json:"message"`
+		Payload map[string]interface{} `json:"payload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if payload.Message == "" {
+		util.WriteError(w, errors.New("message is required"), http.StatusBadRequest)
+		return
+	}
+	sent, failed, err := h.usecase.BroadcastNotification(payload.Message, payload.Payload)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]int{"sent": sent, "failed": failed})
+}
+
 func (h *UserHandler) GeneratePair(w http.ResponseWriter, r *http.Request) {
 	message, err := h.usecase.GeneratePair()
 	if err != nil {
@@ -111,3 +411,58 @@ func (h *UserHandler) GeneratePair(w http.ResponseWriter, r *http.Request) {
 	}
 	util.WriteJSON(w, http.StatusOK, map[string]string{"message": message})
 }
+
+// RecalculateAttendance is a dry run unless called with ?apply=true
+func (h *UserHandler) RecalculateAttendance(w http.ResponseWriter, r *http.Request) {
+	apply := r.URL.Query().Get("apply") == "true"
+	diffs, err := h.usecase.RecalculateAttendance(apply)
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if diffs == nil {
+		diffs = []domain.AttendanceRecalculation{}
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]interface{}{
+		"dryRun": !apply,
+		"users":  diffs,
+	})
+}
+
+func (h *UserHandler) GetDisputes(w http.ResponseWriter, r *http.Request) {
+	disputes, err := h.usecase.GetDisputes(r.URL.Query().Get("status"))
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string][]domain.AttendanceDispute{"disputes": disputes})
+}
+
+func (h *UserHandler) ResolveDispute(w http.ResponseWriter, r *http.Request) {
+	disputeId, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Attended *bool `json:"attended"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		util.WriteError(w, err, http.StatusBadRequest)
+		return
+	}
+	if payload.Attended == nil {
+		util.WriteError(w, errors.New("attended is required"), http.StatusBadRequest)
+		return
+	}
+	err = h.usecase.ResolveDispute(disputeId, *payload.Attended)
+	if errors.Is(err, domain.ErrDisputeNotFound) {
+		util.WriteError(w, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		util.WriteError(w, err, http.StatusInternalServerError)
+		return
+	}
+	util.WriteJSON(w, http.StatusOK, map[string]string{"message": "Dispute resolved"})
+}
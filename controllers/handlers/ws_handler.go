@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"lingo-backend/domain"
+	services "lingo-backend/service"
+	util "lingo-backend/utils"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = (wsPongWait * 9) / 10
+)
+
+// HandleWebSocket pushes the user's events (notifications, matches, partner
+// participation and waitlist status) over a WebSocket. The session token is
+// passed as ?token= since browsers can't set headers on the upgrade request.
+func (h *UserHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	userId, err := h.sessions.ValidateSession(sessionToken(r))
+	if err != nil {
+		util.WriteError(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("websocket upgrade failed:", err)
+		return
+	}
+
+	sub := h.hub.Subscribe(userId)
+	go wsReadPump(conn, sub)
+	h.wsWritePump(conn, sub)
+}
+
+// wsReadPump only keeps the connection alive, clients don't send us anything
+func wsReadPump(conn *websocket.Conn, sub *services.Subscription) {
+	defer sub.Close()
+
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func (h *UserHandler) wsWritePump(conn *websocket.Conn, sub *services.Subscription) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	// Start the client off with where they stand on the waitlist
+	if status, err := h.usecase.GetWaitlistStatus(sub.UserID); err == nil {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		conn.WriteJSON(domain.Event{Type: domain.EventWaitlistStatus, UserID: sub.UserID, Payload: status, CreatedAt: time.Now()})
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.Events:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				sub.Close()
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				sub.Close()
+				return
+			}
+		}
+	}
+}
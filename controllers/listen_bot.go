@@ -3,89 +3,247 @@ package controllers
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
-	repo "lingo-backend/controllers/repository"
 	domain "lingo-backend/domain"
+	"lingo-backend/usecase"
 
 	"cloud.google.com/go/firestore"
-	"github.com/cloudinary/cloudinary-go/v2"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var (
+	activeBotMu sync.RWMutex
+	activeBot   TelegramClient
 
-	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	errBotNotConnected = errors.New("telegram bot is not connected yet")
 )
 
-func ListenToBot(db *sql.DB, firestoreClient *firestore.Client) {
-	// err := godotenv.Load()
-	// if err != nil {
-	// 	log.Fatal("Error loading .env file")
-	// 	return
-	// }
-	var bot *tgbotapi.BotAPI
-	var err error
-	for {
-		bot, err = tgbotapi.NewBotAPI(os.Getenv("BOT_TOKEN"))
-		if err != nil {
-			log.Println(" Telegram bot connection failed, retrying in 10s:", err)
-			time.Sleep(10 * time.Second)
-			continue
+func currentBot() TelegramClient {
+	activeBotMu.RLock()
+	defer activeBotMu.RUnlock()
+	return activeBot
+}
+
+// SendMessage sends a plain text message through the running bot.
+// For private chats the chat ID is the user's Telegram ID.
+func SendMessage(chatID int64, text string) error {
+	bot := currentBot()
+	if bot == nil {
+		return errBotNotConnected
+	}
+	_, err := bot.Send(tgbotapi.NewMessage(chatID, text))
+	return err
+}
+
+// Bot answers the commands users send to the Telegram bot
+type Bot struct {
+	db            *sql.DB
+	pairs         *usecase.PairUsecase
+	users         *usecase.UserUsecase
+	announcer     *PairAnnouncer
+	otps          OtpStore
+	profiles      ProfileStore
+	photos        PhotoUploader
+	conversations ConversationStore
+	streaks       StreakCounter
+	api           TelegramClient
+	commands      map[string]command
+	usernames     sync.Map // user ID -> last username written to the profile
+}
+
+func NewBot(db *sql.DB, firestoreClient *firestore.Client, pairs *usecase.PairUsecase, users *usecase.UserUsecase, announcer *PairAnnouncer) *Bot {
+	b := &Bot{
+		db:            db,
+		pairs:         pairs,
+		users:         users,
+		announcer:     announcer,
+		otps:          sqlOtpStore{db: db},
+		profiles:      firestoreProfiles{client: firestoreClient},
+		photos:        cloudinaryPhotos{},
+		conversations: sqlConversations{db: db},
+		streaks:       sqlStreaks{db: db},
+	}
+	b.commands = b.commandSet()
+	return b
+}
+
+// Listen starts the bot on a connected client. In polling mode it handles
+// updates until Stop is called. In webhook mode it registers the webhook and
+// returns, updates then arrive through ServeWebhook.
+func (b *Bot) Listen(client TelegramClient) {
+	b.api = client
+
+	activeBotMu.Lock()
+	activeBot = client
+	activeBotMu.Unlock()
+
+	if BotMode() == BotModeWebhook {
+		if err := b.setWebhook(); err != nil {
+			log.Println("Failed to set Telegram webhook:", err)
 		}
-		break
+		return
 	}
 
-	bot.Debug = true // For logging
+	// getUpdates is refused while a webhook is set
+	if _, err := client.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Println("Failed to delete Telegram webhook:", err)
+	}
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
-	updates := bot.GetUpdatesChan(u)
+	updates := client.Updates(u)
 
 	for update := range updates {
-		if update.Message != nil && update.Message.IsCommand() {
-			if update.Message.Command() == "start" {
-				user := update.Message.From
-				chatID := update.Message.Chat.ID
-
-				username := user.UserName
-				userID := user.ID
-
-				// Generate random OTP
-				otp := generateOTP(4)
-				var payload = domain.Otp{
-					UserID:   userID,
-					Otp:      otp,
-					Username: username,
-				}
-				repo.InsertOtp(db, payload)
-
-				msg := tgbotapi.NewMessage(chatID, "Hi @"+username+" 👋\nYour OTP is: "+fmt.Sprint(otp))
-				bot.Send(msg)
-
-				// Profile picture URL – requires extra call
-				profilePhotoURL := getUserProfilePhoto(bot, int64(userID), firestoreClient)
-				log.Println("User profile photo URL:", profilePhotoURL)
-				var userProfile = User{
-					UserID:     userID,
-					Username:   username,
-					ProfileURL: profilePhotoURL,
-					CreatedAt:  time.Now(),
-				}
-				err := UpsertUserToFirebase(userProfile)
-				if err != nil {
-					log.Println("Error upserting user to Firebase:", err)
-				} else {
-					log.Println(" User upserted in Firebase:", userID)
-				}
-			}
-		}
+		b.handleUpdate(update)
+	}
+}
+
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	if from := update.SentFrom(); from != nil {
+		b.refreshUsername(from)
+	}
+	switch {
+	case update.Message != nil && update.Message.IsCommand():
+		b.handleCommand(update.Message)
+	case update.Message != nil && update.Message.Chat.IsPrivate() && update.Message.From != nil:
+		b.handleConversation(update.Message)
+	case update.CallbackQuery != nil:
+		b.handleCallback(update.CallbackQuery)
+	case update.MyChatMember != nil:
+		b.handleMembership(update.MyChatMember)
+	}
+}
+
+// handleMembership introduces the bot when it is added to a group
+func (b *Bot) handleMembership(change *tgbotapi.ChatMemberUpdated) {
+	if !change.Chat.IsGroup() && !change.Chat.IsSuperGroup() {
+		return
+	}
+	if inChat(change.OldChatMember) || !inChat(change.NewChatMember) {
+		return
+	}
+	text := "👋 Hi everyone! Send /join or /skip here to answer for today's conversation. An admin can send /announcehere to get the daily pairing posted in this group."
+	if _, err := b.api.Send(tgbotapi.NewMessage(change.Chat.ID, text)); err != nil {
+		log.Printf("failed to greet group %d: %v\n", change.Chat.ID, err)
+	}
+}
+
+func inChat(member tgbotapi.ChatMember) bool {
+	switch member.Status {
+	case "creator", "administrator", "member":
+		return true
+	case "restricted":
+		return member.IsMember
+	}
+	return false
+}
+
+// loginLink is a one-time link into the app at APP_URL, empty when unset
+func (b *Bot) loginLink(userID int64) (string, error) {
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		return "", nil
+	}
+	token, err := b.otps.InsertLoginToken(userID)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(appURL, "/") + "/login?token=" + token, nil
+}
+
+// displayName is how the bot addresses a user, not everyone has a username
+func displayName(user *tgbotapi.User) string {
+	if user.UserName != "" {
+		return "@" + user.UserName
+	}
+	if name := strings.TrimSpace(user.FirstName + " " + user.LastName); name != "" {
+		return name
+	}
+	return "there"
+}
+
+// refreshUsername keeps the profile's username in sync, users can change or
+// remove it at any time. Usernames already seen are skipped to save writes.
+func (b *Bot) refreshUsername(user *tgbotapi.User) {
+	if known, ok := b.usernames.Load(user.ID); ok && known == user.UserName {
+		return
+	}
+	err := b.profiles.UpdateProfile(context.Background(), user.ID, []firestore.Update{
+		{Path: "username", Value: user.UserName},
+	})
+	if err != nil && !errors.Is(err, errProfileNotFound) {
+		log.Printf("failed to refresh username of %d: %v\n", user.ID, err)
+		return
+	}
+	b.usernames.Store(user.ID, user.UserName)
+}
+
+// reply answers in the chat the message came from
+func (b *Bot) reply(msg *tgbotapi.Message, text string) {
+	if _, err := b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, text)); err != nil {
+		log.Printf("failed to reply to %d: %v\n", msg.Chat.ID, err)
+	}
+}
+
+// start syncs the Telegram profile and issues an OTP for logging into the app
+func (b *Bot) start(msg *tgbotapi.Message) {
+	if !msg.Chat.IsPrivate() {
+		b.reply(msg, "Send me /start in a private chat to get your login code.")
+		return
+	}
+	user := msg.From
+	username := user.UserName
+	userID := user.ID
+
+	// The profile has to exist before the user can log in with the code or link
+	profilePhotoURL := b.profilePhoto(userID)
+	log.Println("User profile photo URL:", profilePhotoURL)
+	var userProfile = User{
+		UserID:     userID,
+		Username:   username,
+		ProfileURL: profilePhotoURL,
+		CreatedAt:  time.Now(),
+	}
+	if err := b.profiles.UpsertProfile(context.Background(), userProfile); err != nil {
+		log.Println("Error upserting user to Firebase:", err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	log.Println(" User upserted in Firebase:", userID)
+
+	// Generate random OTP
+	otp := generateOTP(4)
+	var payload = domain.Otp{
+		UserID:   userID,
+		Otp:      otp,
+		Username: username,
+	}
+	if err := b.otps.InsertOtp(payload); err != nil {
+		log.Printf("failed to save OTP for %d: %v\n", userID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+
+	text := fmt.Sprintf("Hi %s 👋\nYour OTP is: %d\nYour user ID is: %d", displayName(user), otp, userID)
+	if link, err := b.loginLink(userID); err != nil {
+		log.Printf("failed to create login link for %d: %v\n", userID, err)
+	} else if link != "" {
+		text += "\n\nOr tap to log in: " + link
+	}
+	b.reply(msg, text)
+
+	if !b.onboarded(userID) {
+		b.startOnboarding(msg)
 	}
 }
 
@@ -99,31 +257,21 @@ func generateOTP(length int) int64 {
 	return rand.Int63n(max-min+1) + min
 }
 
-func getUserProfilePhoto(bot *tgbotapi.BotAPI, userID int64, firestoreClient *firestore.Client) string {
+// profilePhoto copies the user's Telegram profile photo to the photo host,
+// replacing the one uploaded before. Empty when they have none.
+func (b *Bot) profilePhoto(userID int64) string {
 	ctx := context.Background()
 
-	// Step 0: Cloudinary setup
-	cld, _ := cloudinary.NewFromParams(os.Getenv("CLOUD_NAME"), os.Getenv("API_KEY"), os.Getenv("API_SECRET"))
-
-	// Step 1: Get current profile URL from Firestore
-	userDoc := firestoreClient.Collection("users").Doc(fmt.Sprint(userID))
-	docSnap, err := userDoc.Get(ctx)
-	if err == nil && docSnap.Exists() {
-		if oldUrl, ok := docSnap.Data()["profileUrl"].(string); ok && oldUrl != "" {
-			// Extract public_id from the Cloudinary URL
-			publicID := getPublicIDFromURL(oldUrl)
-			if publicID != "" {
-				_, err := cld.Upload.Destroy(ctx, uploader.DestroyParams{PublicID: publicID})
-				if err != nil {
-					log.Println("Cloudinary delete error:", err)
-				}
-				log.Println("Cloudinary delete success:", publicID)
+	if profile, err := b.profiles.Profile(ctx, userID); err == nil {
+		if oldUrl, ok := profile["profileUrl"].(string); ok && oldUrl != "" {
+			if err := b.photos.Destroy(ctx, oldUrl); err != nil {
+				log.Println("Failed to delete old profile photo:", err)
 			}
 		}
 	}
 
 	// get telegram profile photo
-	photos, err := bot.GetUserProfilePhotos(tgbotapi.UserProfilePhotosConfig{
+	photos, err := b.api.GetUserProfilePhotos(tgbotapi.UserProfilePhotosConfig{
 		UserID: userID,
 		Limit:  1,
 	})
@@ -133,15 +281,18 @@ func getUserProfilePhoto(bot *tgbotapi.BotAPI, userID int64, firestoreClient *fi
 	}
 
 	fileID := photos.Photos[0][0].FileID
-	file, _ := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	file, err := b.api.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		log.Println("Error getting photo file:", err)
+		return ""
+	}
 
-	downloadURL := "https://api.telegram.org/file/bot" + bot.Token + "/" + file.FilePath
-	resp, err := http.Get(downloadURL)
+	photo, err := b.api.DownloadFile(file)
 	if err != nil {
 		log.Println("Error downloading photo:", err)
 		return ""
 	}
-	defer resp.Body.Close()
+	defer photo.Close()
 
 	tempFile, err := os.CreateTemp("", "profile_*.jpg")
 	if err != nil {
@@ -149,41 +300,27 @@ func getUserProfilePhoto(bot *tgbotapi.BotAPI, userID int64, firestoreClient *fi
 		return ""
 	}
 	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
 
-	_, err = io.Copy(tempFile, resp.Body)
+	_, err = io.Copy(tempFile, photo)
 	if err != nil {
 		log.Println("Error saving photo:", err)
 		return ""
 	}
 
-	// upload new photo to cloudinary
-	uploadResp, err := cld.Upload.Upload(ctx, tempFile.Name(), uploader.UploadParams{})
+	url, err := b.photos.Upload(ctx, tempFile.Name())
 	if err != nil {
-		log.Println("Cloudinary upload error:", err)
+		log.Println("Photo upload error:", err)
 		return ""
 	}
 
-	// update firestore with new photo URL
-	_, err = userDoc.Update(ctx, []firestore.Update{
-		{Path: "profileUrl", Value: uploadResp.SecureURL},
+	err = b.profiles.UpdateProfile(ctx, userID, []firestore.Update{
+		{Path: "profileUrl", Value: url},
 	})
-	if err != nil {
-		log.Println("Error updating Firestore:", err)
-	}
-
-	log.Println("Public URL:", uploadResp.SecureURL)
-	return uploadResp.SecureURL
-}
-func getPublicIDFromURL(url string) string {
-	// Split the URL by "/" and get the last part
-	parts := strings.Split(url, "/")
-	if len(parts) == 0 {
-		return ""
+	if err != nil && !errors.Is(err, errProfileNotFound) {
+		log.Println("Error updating profile photo:", err)
 	}
-	// Get the filename: profile_1314364420_bikyig.jpg
-	filename := parts[len(parts)-1]
 
-	// Remove the file extension (.jpg, .png, etc.)
-	publicID := strings.TrimSuffix(filename, filepath.Ext(filename))
-	return publicID
+	log.Println("Public URL:", url)
+	return url
 }
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestStart(t *testing.T) {
+	t.Setenv("APP_URL", "https://app.example/")
+	b, client, stores := newTestBot(t, nil, nil)
+
+	const userID = 42
+	client.Photos[userID] = tgbotapi.UserProfilePhotos{
+		TotalCount: 1,
+		Photos:     [][]tgbotapi.PhotoSize{{{FileID: "photo-1"}}},
+	}
+	client.Files["photo-1"] = tgbotapi.File{FileID: "photo-1", FilePath: "photos/1.jpg"}
+	client.Contents["photos/1.jpg"] = []byte("jpeg")
+
+	b.handleUpdate(commandUpdate(userID, "/start"))
+
+	if len(stores.otps) != 1 || stores.otps[0].UserID != userID || stores.otps[0].Username != "user42" {
+		t.Fatalf("stored OTPs = %+v, want one for user 42", stores.otps)
+	}
+	otp := stores.otps[0].Otp
+	if otp < 1000 || otp > 9999 {
+		t.Errorf("OTP %d doesn't have 4 digits", otp)
+	}
+
+	texts := client.SentTexts()
+	if len(texts) == 0 {
+		t.Fatal("nothing was sent")
+	}
+	login := texts[0]
+	if !strings.Contains(login, fmt.Sprintf("Your OTP is: %d", otp)) {
+		t.Errorf("reply %q doesn't contain the OTP", login)
+	}
+	if !strings.Contains(login, "https://app.example/login?token=token-42-0") {
+		t.Errorf("reply %q doesn't contain the login link", login)
+	}
+
+	profile, err := stores.Profile(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("profile wasn't created: %v", err)
+	}
+	if profile["username"] != "user42" {
+		t.Errorf("username = %v, want user42", profile["username"])
+	}
+	if len(stores.uploads) != 1 {
+		t.Fatalf("uploaded %d photos, want 1", len(stores.uploads))
+	}
+	if profile["profileUrl"] != "https://photos.example/profile_1.jpg" {
+		t.Errorf("profileUrl = %v, want the uploaded photo", profile["profileUrl"])
+	}
+
+	// not onboarded yet, so the profile questions start
+	if step, _, _ := stores.Load(userID); step != stepLanguages {
+		t.Errorf("conversation step = %q, want %q", step, stepLanguages)
+	}
+	if last := texts[len(texts)-1]; !strings.Contains(last, "Which languages") {
+		t.Errorf("last message %q doesn't ask for languages", last)
+	}
+}
+
+func TestStartReplacesPhotoOfKnownUser(t *testing.T) {
+	b, client, stores := newTestBot(t, nil, nil)
+
+	const userID = 7
+	stores.profiles[userID] = map[string]interface{}{
+		"username":   "old",
+		"profileUrl": "https://photos.example/old.jpg",
+		"languages":  []interface{}{"English"},
+	}
+	client.Photos[userID] = tgbotapi.UserProfilePhotos{
+		TotalCount: 1,
+		Photos:     [][]tgbotapi.PhotoSize{{{FileID: "photo-7"}}},
+	}
+	client.Files["photo-7"] = tgbotapi.File{FileID: "photo-7", FilePath: "photos/7.jpg"}
+	client.Contents["photos/7.jpg"] = []byte("jpeg")
+
+	b.handleUpdate(commandUpdate(userID, "/start"))
+
+	if len(stores.destroyed) != 1 || stores.destroyed[0] != "https://photos.example/old.jpg" {
+		t.Errorf("destroyed = %v, want the old photo", stores.destroyed)
+	}
+	profile, _ := stores.Profile(context.Background(), userID)
+	if profile["username"] != "user7" || profile["profileUrl"] != "https://photos.example/profile_1.jpg" {
+		t.Errorf("profile = %v, want the new username and photo", profile)
+	}
+	if step, _, _ := stores.Load(userID); step != "" {
+		t.Errorf("onboarded user was asked the profile questions again (step %q)", step)
+	}
+	if texts := client.SentTexts(); len(texts) != 1 {
+		t.Errorf("sent %q, want only the login reply", texts)
+	}
+}
+
+func TestStartWithoutProfile(t *testing.T) {
+	t.Setenv("APP_URL", "https://app.example/")
+	b, client, stores := newTestBot(t, nil, nil)
+	stores.upsertErr = errors.New("firestore down")
+
+	b.handleUpdate(commandUpdate(9, "/start"))
+
+	if len(stores.otps) != 0 || len(stores.tokens) != 0 {
+		t.Errorf("issued OTPs %v and tokens %v for a user without a profile", stores.otps, stores.tokens)
+	}
+	if texts := client.SentTexts(); len(texts) != 1 || texts[0] != somethingWentWrong {
+		t.Errorf("sent %q, want an error reply", texts)
+	}
+}
+
+func TestStartInGroup(t *testing.T) {
+	b, client, stores := newTestBot(t, nil, nil)
+
+	update := commandUpdate(5, "/start")
+	update.Message.Chat = &tgbotapi.Chat{ID: -100, Type: "group"}
+	b.handleUpdate(update)
+
+	if len(stores.otps) != 0 {
+		t.Errorf("an OTP was issued in a group")
+	}
+	if texts := client.SentTexts(); len(texts) != 1 || !strings.Contains(texts[0], "private chat") {
+		t.Errorf("sent %q, want a pointer to the private chat", texts)
+	}
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Onboarding steps, in the order they are asked
+const (
+	stepLanguages    = "languages"
+	stepLevel        = "level"
+	stepTimezone     = "timezone"
+	stepAvailability = "availability"
+)
+
+const maxLanguages = 3
+
+var (
+	levels            = []string{"beginner", "intermediate", "advanced"}
+	availabilitySlots = []string{"morning", "afternoon", "evening"}
+)
+
+// onboardingAnswers are collected step by step and written to the profile at the end
+type onboardingAnswers struct {
+	Languages    []string `json:"languages"`
+	Level        string   `json:"level"`
+	Timezone     string   `json:"timezone"`
+	Availability []string `json:"availability"`
+}
+
+// ask sends a question with an optional reply keyboard
+func (b *Bot) ask(chatID int64, text string, markup interface{}) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if markup != nil {
+		msg.ReplyMarkup = markup
+	}
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("failed to ask %d: %v\n", chatID, err)
+	}
+}
+
+func keyboard(options ...string) tgbotapi.ReplyKeyboardMarkup {
+	var row []tgbotapi.KeyboardButton
+	for _, option := range options {
+		row = append(row, tgbotapi.NewKeyboardButton(option))
+	}
+	markup := tgbotapi.NewReplyKeyboard(row)
+	markup.OneTimeKeyboard = true
+	return markup
+}
+
+// askStep sends the question of a step
+func (b *Bot) askStep(chatID int64, step string) {
+	switch step {
+	case stepLanguages:
+		b.ask(chatID, fmt.Sprintf("🌍 Which languages do you want to practice? Send up to %d, separated by commas (e.g. English, Spanish).", maxLanguages),
+			tgbotapi.NewRemoveKeyboard(true))
+	case stepLevel:
+		b.ask(chatID, "📚 What's your level?", keyboard("Beginner", "Intermediate", "Advanced"))
+	case stepTimezone:
+		b.ask(chatID, "🕒 Which timezone are you in? Send its name, e.g. Europe/Berlin or Africa/Addis_Ababa.", keyboard("Skip"))
+	case stepAvailability:
+		b.ask(chatID, "📆 When are you usually free to talk? Pick one or send several separated by commas.",
+			keyboard("Morning", "Afternoon", "Evening", "Any time"))
+	}
+}
+
+// startOnboarding (re)starts the profile questions from the first step
+func (b *Bot) startOnboarding(msg *tgbotapi.Message) {
+	if err := b.conversations.Save(msg.From.ID, stepLanguages, onboardingAnswers{}); err != nil {
+		log.Printf("failed to start onboarding of %d: %v\n", msg.From.ID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	b.ask(msg.Chat.ID, "Let's set up your profile so we can find you the right partners. Send /cancel to stop at any time.", nil)
+	b.askStep(msg.Chat.ID, stepLanguages)
+}
+
+// onboarded reports whether the user has answered the profile questions
+func (b *Bot) onboarded(userID int64) bool {
+	profile, err := b.profile(userID)
+	if err != nil {
+		return false
+	}
+	languages, _ := profile["languages"].([]interface{})
+	return len(languages) > 0
+}
+
+func (b *Bot) setup(msg *tgbotapi.Message) {
+	if !msg.Chat.IsPrivate() {
+		b.reply(msg, "Send me /setup in a private chat.")
+		return
+	}
+	if _, err := b.profile(msg.From.ID); err != nil {
+		b.reply(msg, "I don't know you yet, send /start first.")
+		return
+	}
+	b.startOnboarding(msg)
+}
+
+func (b *Bot) cancel(msg *tgbotapi.Message) {
+	if err := b.conversations.End(msg.From.ID); err != nil {
+		log.Printf("failed to cancel conversation of %d: %v\n", msg.From.ID, err)
+	}
+	b.ask(msg.Chat.ID, "OK, stopped. Send /setup whenever you want to continue.", tgbotapi.NewRemoveKeyboard(true))
+}
+
+// handleConversation takes a plain message as the answer to the current step
+func (b *Bot) handleConversation(msg *tgbotapi.Message) {
+	userID := msg.From.ID
+	step, answers, err := b.conversations.Load(userID)
+	if err != nil {
+		log.Printf("failed to load conversation of %d: %v\n", userID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	if step == "" {
+		b.reply(msg, "Send /help to see what I can do.")
+		return
+	}
+
+	text := strings.TrimSpace(msg.Text)
+	next := ""
+	switch step {
+	case stepLanguages:
+		answers.Languages = splitAnswer(text)
+		if len(answers.Languages) == 0 || len(answers.Languages) > maxLanguages {
+			b.askStep(msg.Chat.ID, step)
+			return
+		}
+		next = stepLevel
+	case stepLevel:
+		answers.Level = strings.ToLower(text)
+		if !contains(levels, answers.Level) {
+			b.askStep(msg.Chat.ID, step)
+			return
+		}
+		next = stepTimezone
+	case stepTimezone:
+		if !strings.EqualFold(text, "skip") {
+			if _, err := time.LoadLocation(text); err != nil || text == "" {
+				b.reply(msg, "I don't know that timezone.")
+				b.askStep(msg.Chat.ID, step)
+				return
+			}
+			answers.Timezone = text
+		}
+		next = stepAvailability
+	case stepAvailability:
+		answers.Availability = nil
+		for _, slot := range splitAnswer(strings.ToLower(text)) {
+			switch {
+			case slot == "any time" || slot == "any":
+				answers.Availability = availabilitySlots
+			case contains(availabilitySlots, slot):
+				answers.Availability = append(answers.Availability, slot)
+			}
+		}
+		if len(answers.Availability) == 0 {
+			b.askStep(msg.Chat.ID, step)
+			return
+		}
+	}
+
+	if next != "" {
+		if err := b.conversations.Save(userID, next, answers); err != nil {
+			log.Printf("failed to save conversation of %d: %v\n", userID, err)
+			b.reply(msg, somethingWentWrong)
+			return
+		}
+		b.askStep(msg.Chat.ID, next)
+		return
+	}
+	b.finishOnboarding(msg, answers)
+}
+
+// finishOnboarding writes the answers to the profile used for pairing
+func (b *Bot) finishOnboarding(msg *tgbotapi.Message, answers onboardingAnswers) {
+	userID := msg.From.ID
+	updates := []firestore.Update{
+		{Path: "languages", Value: answers.Languages},
+		{Path: "level", Value: answers.Level},
+		{Path: "availability", Value: answers.Availability},
+		{Path: "onboardedAt", Value: time.Now()},
+	}
+	if answers.Timezone != "" {
+		updates = append(updates, firestore.Update{Path: "timezone", Value: answers.Timezone})
+	}
+	err := b.profiles.UpdateProfile(context.Background(), userID, updates)
+	if errors.Is(err, errProfileNotFound) {
+		b.reply(msg, "I don't know you yet, send /start first.")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to save profile of %d: %v\n", userID, err)
+		b.reply(msg, somethingWentWrong)
+		return
+	}
+	if err := b.conversations.End(userID); err != nil {
+		log.Printf("failed to end conversation of %d: %v\n", userID, err)
+	}
+	b.ask(msg.Chat.ID, fmt.Sprintf("✅ All set! You'll practice %s at %s level. Send /setup to change it.",
+		strings.Join(answers.Languages, ", "), answers.Level), tgbotapi.NewRemoveKeyboard(true))
+}
+
+// splitAnswer splits a comma separated answer, dropping empty entries
+func splitAnswer(text string) []string {
+	var parts []string
+	for _, part := range strings.Split(text, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
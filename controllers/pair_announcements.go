@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	util "lingo-backend/utils"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback data of the participation buttons, followed by the pair ID
+const (
+	callbackJoin = "join:"
+	callbackSkip = "skip:"
+)
+
+// PairAnnouncer sends the daily pair announcement with participation buttons
+// and keeps every member's copy up to date as they answer.
+type PairAnnouncer struct {
+	db *sql.DB
+}
+
+func NewPairAnnouncer(db *sql.DB) *PairAnnouncer {
+	return &PairAnnouncer{db: db}
+}
+
+type pairMember struct {
+	id            int64
+	username      string
+	participating sql.NullBool // NULL until they answer
+	rematched     bool         // moved to a new partner, the pair is frozen
+}
+
+type pairState struct {
+	id       string
+	members  []pairMember
+	deadline sql.NullTime
+}
+
+func (a *PairAnnouncer) loadPair(pairId string) (pairState, error) {
+	state := pairState{id: pairId}
+	rows, err := a.db.Query(`
+		SELECT m.userid, m.username, pp.is_participating, pp.rematched_to IS NOT NULL, p.deadline
+		FROM pairs p
+		CROSS JOIN LATERAL (VALUES (p.user1id, p.username1), (p.user2id, p.username2), (p.user3id, p.username3)) AS m(userid, username)
+		LEFT JOIN pair_participation pp ON pp.pair_id = p.id AND pp.userid = m.userid
+		WHERE p.id = $1 AND COALESCE(m.userid, 0) <> 0`, pairId)
+	if err != nil {
+		return state, fmt.Errorf("failed to load pair %s: %w", pairId, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m pairMember
+		var username sql.NullString
+		if err := rows.Scan(&m.id, &username, &m.participating, &m.rematched, &state.deadline); err != nil {
+			return state, err
+		}
+		m.username = username.String
+		state.members = append(state.members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return state, err
+	}
+	if len(state.members) == 0 {
+		return state, sql.ErrNoRows
+	}
+	return state, nil
+}
+
+// render builds the announcement text and, while answers are still
+// accepted, the participation buttons
+func (s pairState) render() (string, *tgbotapi.InlineKeyboardMarkup) {
+	var sb strings.Builder
+	sb.WriteString("📅 Today's conversation\n")
+	rematched := false
+	for _, m := range s.members {
+		state := "⏳ waiting"
+		if m.rematched {
+			state, rematched = "🔁 rematched", true
+		} else if m.participating.Valid && m.participating.Bool {
+			state = "✅ in"
+		} else if m.participating.Valid {
+			state = "❌ can't today"
+		}
+		fmt.Fprintf(&sb, "%s - %s\n", util.Mention(m.username), state)
+	}
+
+	open := !s.deadline.Valid || s.deadline.Time.After(time.Now())
+	if rematched {
+		sb.WriteString("This pair has been split up for today.")
+		return sb.String(), nil
+	}
+	if !open {
+		sb.WriteString("Answers are closed for today.")
+		return sb.String(), nil
+	}
+	if s.deadline.Valid {
+		loc, _ := time.LoadLocation(util.DefaultTimezone())
+		fmt.Fprintf(&sb, "Let your partner know before %s.", s.deadline.Time.In(loc).Format("15:04 MST"))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("✅ I'm in", callbackJoin+s.id),
+		tgbotapi.NewInlineKeyboardButtonData("❌ Can't today", callbackSkip+s.id),
+	))
+	return sb.String(), &keyboard
+}
+
+// Announce sends the pair's announcement to a member and remembers the
+// message so it can be edited later
+func (a *PairAnnouncer) Announce(chatID int64, pairId string) error {
+	bot := currentBot()
+	if bot == nil {
+		return errBotNotConnected
+	}
+	state, err := a.loadPair(pairId)
+	if err != nil {
+		return err
+	}
+	text, keyboard := state.render()
+	msg := tgbotapi.NewMessage(chatID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = keyboard
+	}
+	sent, err := bot.Send(msg)
+	if err != nil {
+		return err
+	}
+	_, err = a.db.Exec(`
+		INSERT INTO pair_announcements (pair_id, chat_id, message_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (pair_id, chat_id) DO UPDATE SET message_id = EXCLUDED.message_id, created_at = NOW()`,
+		pairId, chatID, sent.MessageID)
+	return err
+}
+
+// ParticipationChanged edits every announcement of the pair to show the new
+// answers. It runs in the background so callers aren't held up by Telegram.
+func (a *PairAnnouncer) ParticipationChanged(pairId string) {
+	go func() {
+		if err := a.refresh(pairId); err != nil {
+			log.Printf("failed to refresh announcements of pair %s: %v\n", pairId, err)
+		}
+	}()
+}
+
+func (a *PairAnnouncer) refresh(pairId string) error {
+	bot := currentBot()
+	if bot == nil {
+		return errBotNotConnected
+	}
+	rows, err := a.db.Query(`SELECT chat_id, message_id FROM pair_announcements WHERE pair_id = $1`, pairId)
+	if err != nil {
+		return err
+	}
+	type announcement struct {
+		chatID    int64
+		messageID int
+	}
+	var announcements []announcement
+	for rows.Next() {
+		var an announcement
+		if err := rows.Scan(&an.chatID, &an.messageID); err != nil {
+			rows.Close()
+			return err
+		}
+		announcements = append(announcements, an)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(announcements) == 0 {
+		return err
+	}
+
+	state, err := a.loadPair(pairId)
+	if err != nil {
+		return err
+	}
+	text, keyboard := state.render()
+	for _, an := range announcements {
+		edit := tgbotapi.NewEditMessageText(an.chatID, an.messageID, text)
+		edit.ReplyMarkup = keyboard
+		if _, err := bot.Send(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+			log.Printf("failed to edit announcement %d in chat %d: %v\n", an.messageID, an.chatID, err)
+		}
+	}
+	return nil
+}
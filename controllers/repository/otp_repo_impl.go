@@ -3,12 +3,15 @@ package repository
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"lingo-backend/domain"
+	util "lingo-backend/utils"
+	"os"
 	"time"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type OtpRepositoryImpl struct {
@@ -24,47 +27,118 @@ func NewOtpRepository(db *sql.DB, firestore *firestore.Client) *OtpRepositoryImp
 }
 
 func (r *OtpRepositoryImpl) SaveOtp(otp domain.Otp) error {
-	query := `INSERT INTO otp (userid, otp, username, createdat) VALUES ($1, $2, $3, NOW())`
+	query := `INSERT INTO otp (userid, otp, username, createdat) VALUES ($1, $2, NULLIF($3, ''), NOW())
+	ON CONFLICT (userid) DO UPDATE SET otp = EXCLUDED.otp, username = EXCLUDED.username, createdat = NOW(), failed_attempts = 0`
 	_, err := r.db.Exec(query, otp.UserID, otp.Otp, otp.Username)
 	if err != nil {
 		return err
 	}
 	return nil
 }
-func (r *OtpRepositoryImpl) CheckOtp(username string, otp int64) (*domain.User, error) {
-	query := `SELECT createdat FROM otp WHERE LOWER(username) = LOWER($1) AND otp = $2`
+
+// maxOtpAttempts is how many wrong codes an OTP survives. With 4 digits the
+// code could otherwise be guessed by trying them all.
+const maxOtpAttempts = 5
+
+func (r *OtpRepositoryImpl) CheckOtp(userId int64, username string, otp int64) (*domain.User, error) {
+	// Usernames are optional on Telegram and can move between accounts, so
+	// the user ID wins whenever the client knows it. A wrong code is counted
+	// in the same statement so parallel guesses can't get past the limit.
+	query := `
+	UPDATE otp SET failed_attempts = failed_attempts + CASE WHEN otp = $3 THEN 0 ELSE 1 END
+	WHERE id = (
+		SELECT id FROM otp
+		WHERE ($1 <> 0 AND userid = $1) OR ($1 = 0 AND $2 <> '' AND LOWER(username) = LOWER($2))
+		ORDER BY createdat DESC
+		LIMIT 1
+	) AND failed_attempts < $4
+	RETURNING id, userid, createdat, otp = $3, failed_attempts`
+	var id, userID int64
 	var createdAt sql.NullTime
+	var matched bool
+	var failed int
 
-	err := r.db.QueryRow(query, username, otp).Scan(&createdAt)
+	err := r.db.QueryRow(query, userId, username, otp, maxOtpAttempts).Scan(&id, &userID, &createdAt, &matched, &failed)
 	if err == sql.ErrNoRows {
-		return nil, errors.New("Invalid OTP")
+		return nil, domain.ErrInvalidOtp
 	}
 	if err != nil {
 		return nil, err
 	}
+	if !matched {
+		if failed < maxOtpAttempts {
+			return nil, domain.ErrInvalidOtp
+		}
+		_, _ = r.db.Exec(`DELETE FROM otp WHERE id = $1`, id)
+		return nil, domain.ErrOtpLocked
+	}
 
-	query = `SELECT userid FROM otp WHERE otp=$1 AND LOWER(username)=LOWER($2)`
-	var userID int64
-	err = r.db.QueryRow(query, otp, username).Scan(&userID)
+	expiration := createdAt.Time.Add(30 * time.Minute)
+	if !createdAt.Valid || expiration.Before(time.Now()) {
+		_, _ = r.db.Exec(`DELETE FROM otp WHERE id = $1`, id)
+		return nil, domain.ErrOtpExpired
+	}
+
+	user, err := r.loadUser(userID)
 	if err != nil {
 		return nil, err
 	}
-	user, err := r.firestore.Collection("users").Doc(fmt.Sprint(userID)).Get(context.Background())
+
+	_, err = r.db.Exec(`DELETE FROM otp WHERE id = $1`, id)
 	if err != nil {
 		return nil, err
 	}
-	println("user", user.Data())
-	expiration := createdAt.Time.Add(30 * time.Minute)
-	if !createdAt.Valid || expiration.Before(time.Now()) {
-		_, _ = r.db.Exec(`DELETE FROM otp WHERE LOWER(username) = LOWER($1) AND otp = $2`, username, otp)
-		return nil, errors.New("OTP has expired. Please request a new one.")
+	return user, nil
+}
+
+// ExchangeLoginToken redeems the token only once the user's profile loaded,
+// a failed load leaves the link usable. The row lock keeps two requests from
+// redeeming the same token.
+func (r *OtpRepositoryImpl) ExchangeLoginToken(token string) (*domain.User, error) {
+	if token == "" {
+		return nil, domain.ErrInvalidLogin
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := util.HashToken(token)
+	var userID int64
+	err = tx.QueryRow(`
+		SELECT userid FROM login_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE`, tokenHash).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, domain.ErrInvalidLogin
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = r.db.Exec(`DELETE FROM otp WHERE LOWER(username) = LOWER($1) AND otp = $2`, username, otp)
+	user, err := r.loadUser(userID)
 	if err != nil {
 		return nil, err
 	}
+	if _, err := tx.Exec(`UPDATE login_tokens SET used_at = NOW() WHERE token_hash = $1`, tokenHash); err != nil {
+		return nil, fmt.Errorf("failed to redeem login token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
 
+// loadUser reads the profile the bot created for a Telegram user
+func (r *OtpRepositoryImpl) loadUser(userID int64) (*domain.User, error) {
+	user, err := r.firestore.Collection("users").Doc(fmt.Sprint(userID)).Get(context.Background())
+	if status.Code(err) == codes.NotFound {
+		return nil, domain.ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
 	return &domain.User{
 		ID:         safeInt64(user.Data()["userId"]),
 		Username:   safeString(user.Data()["username"]),
@@ -72,8 +146,46 @@ func (r *OtpRepositoryImpl) CheckOtp(username string, otp int64) (*domain.User,
 		MissCount:  safeInt64(user.Data()["missCount"]),
 		Attendance: safeInt64(user.Data()["attendance"]),
 	}, nil
+}
+
+// sessionTTL is how long a login stays valid, configured with SESSION_TTL
+func sessionTTL() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("SESSION_TTL"))
+	if err != nil || d <= 0 {
+		return 30 * 24 * time.Hour
+	}
+	return d
+}
 
+func (r *OtpRepositoryImpl) CreateSession(userId int64) (string, error) {
+	token, err := util.NewToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = r.db.Exec(`INSERT INTO sessions (token_hash, userid, expires_at) VALUES ($1, $2, $3)`,
+		util.HashToken(token), userId, time.Now().Add(sessionTTL()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %w", err)
+	}
+	return token, nil
 }
+
+func (r *OtpRepositoryImpl) ValidateSession(token string) (int64, error) {
+	if token == "" {
+		return 0, domain.ErrInvalidSession
+	}
+	var userId int64
+	err := r.db.QueryRow(`SELECT userid FROM sessions WHERE token_hash = $1 AND expires_at > $2`,
+		util.HashToken(token), time.Now()).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, domain.ErrInvalidSession
+	}
+	if err != nil {
+		return 0, err
+	}
+	return userId, nil
+}
+
 func safeInt64(value interface{}) int64 {
 	if value == nil {
 		return 0
@@ -100,11 +212,13 @@ func safeString(value interface{}) string {
 func InsertOtp(db *sql.DB, otp domain.Otp) error {
 	query := `
 	INSERT INTO otp (userid, otp, username, createdat)
-	VALUES ($1, $2, $3, NOW())
-	ON CONFLICT (userid, username)
+	VALUES ($1, $2, NULLIF($3, ''), NOW())
+	ON CONFLICT (userid)
 	DO UPDATE SET
 		otp = EXCLUDED.otp,
-		createdat = NOW()
+		username = EXCLUDED.username,
+		createdat = NOW(),
+		failed_attempts = 0
 `
 
 	_, err := db.Exec(query, otp.UserID, otp.Otp, otp.Username)
@@ -113,3 +227,36 @@ func InsertOtp(db *sql.DB, otp domain.Otp) error {
 	}
 	return nil
 }
+
+// loginTokenTTL is how long a login link works, configured with LOGIN_TOKEN_TTL
+func loginTokenTTL() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("LOGIN_TOKEN_TTL"))
+	if err != nil || d <= 0 {
+		return 10 * time.Minute
+	}
+	return d
+}
+
+// InsertLoginToken issues a one-time login token for the Telegram user.
+// Older links of the user stop working.
+func InsertLoginToken(db *sql.DB, userId int64) (string, error) {
+	token, err := util.NewToken()
+	if err != nil {
+		return "", err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM login_tokens WHERE userid = $1`, userId); err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`INSERT INTO login_tokens (token_hash, userid, expires_at) VALUES ($1, $2, $3)`,
+		util.HashToken(token), userId, time.Now().Add(loginTokenTTL()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create login token: %w", err)
+	}
+	return token, tx.Commit()
+}
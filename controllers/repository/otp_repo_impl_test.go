@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"lingo-backend/db/dbtest"
+	"lingo-backend/domain"
+)
+
+func TestCheckOtpLocksAfterFailedAttempts(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := &OtpRepositoryImpl{db: db}
+
+	if err := InsertOtp(db, domain.Otp{UserID: 1, Otp: 1234, Username: "user1"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < maxOtpAttempts; i++ {
+		if _, err := repo.CheckOtp(1, "", 1111); !errors.Is(err, domain.ErrInvalidOtp) {
+			t.Fatalf("wrong code %d: got %v, want ErrInvalidOtp", i, err)
+		}
+	}
+	if _, err := repo.CheckOtp(1, "", 1111); !errors.Is(err, domain.ErrOtpLocked) {
+		t.Fatalf("last wrong code: got %v, want ErrOtpLocked", err)
+	}
+	if _, err := repo.CheckOtp(1, "", 1234); !errors.Is(err, domain.ErrInvalidOtp) {
+		t.Fatalf("right code after the lock: got %v, want ErrInvalidOtp", err)
+	}
+
+	// a new code starts over
+	if err := InsertOtp(db, domain.Otp{UserID: 1, Otp: 5678, Username: "user1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CheckOtp(1, "", 1111); !errors.Is(err, domain.ErrInvalidOtp) {
+		t.Fatalf("wrong code for the new OTP: got %v, want ErrInvalidOtp", err)
+	}
+	var failed int
+	if err := db.QueryRow(`SELECT failed_attempts FROM otp WHERE userid = 1`).Scan(&failed); err != nil {
+		t.Fatal(err)
+	}
+	if failed != 1 {
+		t.Errorf("failed_attempts = %d, want 1", failed)
+	}
+}
@@ -1,16 +1,41 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"lingo-backend/domain"
+	services "lingo-backend/service"
+	util "lingo-backend/utils"
+	"log"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"firebase.google.com/go/v4/db"
 )
 
+// waitlistLockKey serializes everything that claims users from the waitlist
+// or the rematch queue, so a waiting user can never be matched twice.
+const waitlistLockKey = 74200001
+
 type PairRepositoryImpl struct {
-	db *sql.DB
+	db         *sql.DB
+	firestore  *firestore.Client
+	rtdbClient *db.Client
+	events     domain.EventPublisher
+	notifier   domain.Notifier
+	listener   domain.ParticipationListener
 }
 
-func NewPairRepository(db *sql.DB) *PairRepositoryImpl {
-	return &PairRepositoryImpl{db: db}
+func NewPairRepository(db *sql.DB, firestore *firestore.Client, rtdbClient *db.Client, events domain.EventPublisher, notifier domain.Notifier, listener domain.ParticipationListener) *PairRepositoryImpl {
+	return &PairRepositoryImpl{
+		db:         db,
+		firestore:  firestore,
+		rtdbClient: rtdbClient,
+		events:     events,
+		notifier:   notifier,
+		listener:   listener,
+	}
 }
 
 func (s *PairRepositoryImpl) GetDailyPairs(userId int64) (domain.Pair, error) {
@@ -24,22 +49,26 @@ func (s *PairRepositoryImpl) GetDailyPairs(userId int64) (domain.Pair, error) {
 		COALESCE(pp1.is_participating, false) AS user1participating,
 		COALESCE(pp2.is_participating, false) AS user2participating,
 		COALESCE(pp3.is_participating, false) AS user3participating,
-		(p.user3id IS NOT NULL) AS specialgroup
+		(COALESCE(p.user3id, 0) <> 0) AS specialgroup,
+		p.deadline
 	FROM pairs p
 	LEFT JOIN pair_participation pp1 ON pp1.pair_id = p.id AND pp1.userid = p.user1id
 	LEFT JOIN pair_participation pp2 ON pp2.pair_id = p.id AND pp2.userid = p.user2id
 	LEFT JOIN pair_participation pp3 ON pp3.pair_id = p.id AND pp3.userid = p.user3id
 	WHERE (p.user1id = $1 OR p.user2id = $1 OR p.user3id = $1)
 	  AND p.date = CURRENT_DATE
+	  AND NOT EXISTS (SELECT 1 FROM pair_participation r WHERE r.pair_id = p.id AND r.userid = $1 AND r.rematched_to IS NOT NULL)
 	LIMIT 1;
 	`
 
+	var deadline sql.NullTime
 	err := s.db.QueryRow(query, userId).Scan(
 		&pair.ID,
 		&pair.User1ID, &pair.User2ID, &pair.User3ID,
 		&pair.Username1, &pair.Username2, &pair.Username3,
 		&pair.User1Participating, &pair.User2Participating, &pair.User3Participating,
 		&pair.SpecialGroup,
+		&deadline,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -47,17 +76,405 @@ func (s *PairRepositoryImpl) GetDailyPairs(userId int64) (domain.Pair, error) {
 		}
 		return pair, err
 	}
+	if deadline.Valid {
+		pair.Deadline = deadline.Time.Format(time.RFC3339)
+	}
 
 	return pair, nil
 }
 
 func (s *PairRepositoryImpl) UpdatePairParticipation(pairId string, userId int64, participating bool) error {
+	// Only members can answer, and only until the pair's deadline. Repeating
+	// the current answer doesn't touch the row, so nobody is notified twice.
 	query := `
-	INSERT INTO pair_participation (pair_id, userid, is_participating)
-	VALUES ($1, $2, $3)
-	ON CONFLICT (pair_id, userid) DO UPDATE SET is_participating = $3;
+	INSERT INTO pair_participation (pair_id, userid, is_participating, responded_at)
+	SELECT p.id, $2, $3, NOW()
+	FROM pairs p
+	WHERE p.id = $1
+	  AND $2 IN (p.user1id, p.user2id, p.user3id)
+	  AND (p.deadline IS NULL OR p.deadline > NOW())
+	  AND NOT EXISTS (SELECT 1 FROM pair_participation r WHERE r.pair_id = p.id AND r.rematched_to IS NOT NULL)
+	ON CONFLICT (pair_id, userid) DO UPDATE SET is_participating = $3, responded_at = NOW()
+	WHERE pair_participation.is_participating IS DISTINCT FROM $3;
 	`
 
-	_, err := s.db.Exec(query, pairId, userId, participating)
+	res, err := s.db.Exec(query, pairId, userId, participating)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		s.publishParticipation(pairId, userId, participating)
+		s.listener.ParticipationChanged(pairId)
+		if participating {
+			s.cancelRematch(pairId)
+		} else if err := s.rematchStranded(pairId); err != nil {
+			log.Printf("failed to rematch members of pair %s: %v\n", pairId, err)
+		}
+		return nil
+	}
+
+	var deadline sql.NullTime
+	var rematched bool
+	err = s.db.QueryRow(`
+		SELECT deadline, EXISTS (SELECT 1 FROM pair_participation r WHERE r.pair_id = p.id AND r.rematched_to IS NOT NULL)
+		FROM pairs p WHERE id = $1 AND $2 IN (user1id, user2id, user3id)`, pairId, userId).Scan(&deadline, &rematched)
+	if err == sql.ErrNoRows {
+		return domain.ErrNotInPair
+	}
+	if err != nil {
+		return err
+	}
+	if rematched {
+		return domain.ErrPairRematched
+	}
+	if !deadline.Valid || deadline.Time.After(time.Now()) {
+		return nil // already the current answer
+	}
+	return fmt.Errorf("%w (deadline was %s)", domain.ErrParticipationClosed, deadline.Time.Format(time.RFC3339))
+}
+
+// SetParticipationDeadline overrides the deadline for every pair on the given
+// date, including pairs that will only be generated later that day.
+func (s *PairRepositoryImpl) SetParticipationDeadline(date string, deadline time.Time) (int64, error) {
+	_, err := s.db.Exec(`
+	INSERT INTO participation_deadlines (date, deadline) VALUES ($1, $2)
+	ON CONFLICT (date) DO UPDATE SET deadline = EXCLUDED.deadline, updated_at = NOW()`, date, deadline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save deadline: %w", err)
+	}
+
+	res, err := s.db.Exec(`UPDATE pairs SET deadline = $2 WHERE date = $1`, date, deadline)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update pairs: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// rematchStranded looks for a member left alone after everyone else in the
+// pair declined and matches them with another stranded or waitlisted user.
+// When there's nobody available they wait in the rematch queue for the next
+// decline. The new pairing is stored as a pair of its own and the members'
+// rows in their original pairs point at it, which freezes the original pairs
+// and makes running this again a no-op.
+func (s *PairRepositoryImpl) rematchStranded(pairId string) error {
+	ctx := context.Background()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.userid, m.username, to_char(p.date, 'YYYY-MM-DD'), p.deadline
+		FROM pairs p
+		CROSS JOIN LATERAL (VALUES
+			(p.user1id, p.username1), (p.user2id, p.username2), (p.user3id, p.username3)
+		) AS m(userid, username)
+		LEFT JOIN pair_participation pp ON pp.pair_id = p.id AND pp.userid = m.userid
+		WHERE p.id = $1 AND m.userid IS NOT NULL AND m.userid <> 0
+		  AND pp.is_participating IS DISTINCT FROM false`, pairId)
+	if err != nil {
+		return err
+	}
+	var remaining []services.ChatMember
+	var date string
+	var deadline sql.NullTime
+	for rows.Next() {
+		var member services.ChatMember
+		if err := rows.Scan(&member.ID, &member.Username, &date, &deadline); err != nil {
+			rows.Close()
+			return err
+		}
+		remaining = append(remaining, member)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(remaining) != 1 {
+		// Either nobody is left or the others still have each other
+		return nil
+	}
+
+	stranded := remaining[0]
+	stranded.ProfileURL = s.profileUrl(ctx, stranded.ID)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, waitlistLockKey); err != nil {
+		return err
+	}
+
+	var rematched bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pair_participation WHERE pair_id = $1 AND rematched_to IS NOT NULL)`,
+		pairId).Scan(&rematched)
+	if err != nil {
+		return err
+	}
+	if rematched {
+		return nil
+	}
+
+	// Prefer someone stranded on the same date, then whoever waits longest on the waitlist
+	var partner services.ChatMember
+	var profileUrl, partnerPairId sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM rematch_queue
+		WHERE (userid, date) = (
+			SELECT userid, date FROM rematch_queue
+			WHERE date = $1 AND userid <> $2
+			ORDER BY createdat
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING userid, username, profileurl, pair_id`, date, stranded.ID).Scan(&partner.ID, &partner.Username, &profileUrl, &partnerPairId)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRowContext(ctx, `
+			DELETE FROM waitlist
+			WHERE id = (
+				SELECT id FROM waitlist
+				WHERE userid <> $1
+				ORDER BY createdAt
+				LIMIT 1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING userid, username, profileurl`, stranded.ID).Scan(&partner.ID, &partner.Username, &profileUrl)
+	}
+	if err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO rematch_queue (userid, date, pair_id, username, profileurl)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (userid, date) DO NOTHING`, stranded.ID, date, pairId, stranded.Username, stranded.ProfileURL)
+		if err != nil {
+			return err
+		}
+		log.Printf("⏳ User %d is waiting for a rematch on %s\n", stranded.ID, date)
+		return tx.Commit()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find a rematch partner: %w", err)
+	}
+	partner.ProfileURL = profileUrl.String
+
+	chatId := services.ChatID(stranded.ID, partner.ID)
+	if err := saveRematch(ctx, tx, chatId, date, deadline, stranded, partner); err != nil {
+		return err
+	}
+	for member, oldPairId := range map[int64]string{stranded.ID: pairId, partner.ID: partnerPairId.String} {
+		if oldPairId == "" {
+			continue // came from the waitlist, no daily pair
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE pair_participation SET rematched_to = $3 WHERE pair_id = $1 AND userid = $2`,
+			oldPairId, member, chatId); err != nil {
+			return fmt.Errorf("failed to record rematch: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	message := "Your partner couldn't make it today, so we found you a new one!"
+	err = services.CreateChat(ctx, s.rtdbClient, chatId, "Daily Match", message, message,
+		[]services.ChatMember{stranded, partner})
+	if err != nil {
+		return err
+	}
+
+	for _, pair := range [][2]services.ChatMember{{stranded, partner}, {partner, stranded}} {
+		me, other := pair[0], pair[1]
+		_, err := s.notifier.Notify(domain.Notification{
+			RecipientID: me.ID,
+			Type:        domain.NotificationMatch,
+			Message:     message,
+			Payload:     map[string]interface{}{"chatId": chatId, "pairId": chatId, "partnerId": other.ID, "rematch": true, "date": date},
+		})
+		if err != nil {
+			return err
+		}
+		s.events.Publish(me.ID, domain.Event{Type: domain.EventMatchFound, Payload: map[string]interface{}{
+			"chatId": chatId,
+			"partner": map[string]interface{}{
+				"id":         other.ID,
+				"username":   other.Username,
+				"profileUrl": other.ProfileURL,
+			},
+		}})
+	}
+	log.Printf("🔁 User %d rematched with %d for %s\n", stranded.ID, partner.ID, date)
+	return nil
+}
+
+// saveRematch stores the new pairing like a daily pair, with the original
+// pair's date and deadline. Both members are taken to be in.
+func saveRematch(ctx context.Context, tx *sql.Tx, pairId, date string, deadline sql.NullTime, a, b services.ChatMember) error {
+	if b.ID < a.ID {
+		a, b = b, a
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO pairs (id, user1id, user2id, username1, username2, date, status, deadline)
+		VALUES ($1, $2, $3, $4, $5, $6, 'pending', $7)
+		ON CONFLICT (id) DO NOTHING`, pairId, a.ID, b.ID, a.Username, b.Username, date, deadline)
+	if err != nil {
+		return fmt.Errorf("failed to save rematch: %w", err)
+	}
+	for _, pair := range [][2]services.ChatMember{{a, b}, {b, a}} {
+		me, other := pair[0], pair[1]
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO pair_participation (pair_id, userid, is_participating, responded_at)
+			VALUES ($1, $2, true, NOW())
+			ON CONFLICT (pair_id, userid) DO NOTHING`, pairId, me.ID); err != nil {
+			return fmt.Errorf("failed to save rematch participation: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO pair_history (date, pair_id, userid, partner_id, partner_username)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT DO NOTHING`, date, pairId, me.ID, other.ID, other.Username); err != nil {
+			return fmt.Errorf("failed to save rematch history: %w", err)
+		}
+	}
+	return nil
+}
+
+// cancelRematch takes the pair's members out of the rematch queue once a
+// decliner changes their mind and the pair is whole again.
+func (s *PairRepositoryImpl) cancelRematch(pairId string) {
+	if _, err := s.db.Exec(`DELETE FROM rematch_queue WHERE pair_id = $1`, pairId); err != nil {
+		log.Printf("failed to cancel rematch for pair %s: %v\n", pairId, err)
+	}
+}
+
+func (s *PairRepositoryImpl) profileUrl(ctx context.Context, userId int64) string {
+	snap, err := s.firestore.Collection("users").Doc(fmt.Sprint(userId)).Get(ctx)
+	if err != nil || !snap.Exists() {
+		return ""
+	}
+	return safeString(snap.Data()["profileUrl"])
+}
+
+// ConfirmSession stores the reporter's account of who showed up to the session.
+// Reporting again on the same member replaces the earlier report. Reports
+// are only taken once the session can have happened: after the pair's
+// deadline, or from the pair's date on when it has none.
+func (s *PairRepositoryImpl) ConfirmSession(pairId string, reporterId int64, reports []domain.SessionConfirmation) error {
+	var user1, user2 int64
+	var user3 sql.NullInt64
+	var date string
+	var held bool
+	err := s.db.QueryRow(`
+		SELECT user1id, user2id, user3id, to_char(date, 'YYYY-MM-DD'),
+			CASE WHEN deadline IS NULL THEN date <= CURRENT_DATE ELSE deadline <= NOW() END
+		FROM pairs WHERE id = $1`, pairId).
+		Scan(&user1, &user2, &user3, &date, &held)
+	if err == sql.ErrNoRows {
+		return domain.ErrNotInPair
+	}
+	if err != nil {
+		return err
+	}
+	members := map[int64]bool{user1: true, user2: true}
+	if user3.Valid && user3.Int64 != 0 {
+		members[user3.Int64] = true
+	}
+	if !members[reporterId] {
+		return domain.ErrNotInPair
+	}
+	if !held {
+		return domain.ErrSessionNotHeld
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, report := range reports {
+		if report.SubjectID == reporterId || !members[report.SubjectID] {
+			return fmt.Errorf("user %d: %w", report.SubjectID, domain.ErrNotInPair)
+		}
+		_, err := tx.Exec(`
+		INSERT INTO session_confirmations (pair_id, date, reporter_id, subject_id, showed_up)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (pair_id, date, reporter_id, subject_id) DO UPDATE SET showed_up = EXCLUDED.showed_up, created_at = NOW()`,
+			pairId, date, reporterId, report.SubjectID, report.ShowedUp)
+		if err != nil {
+			return fmt.Errorf("failed to save confirmation: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *PairRepositoryImpl) GetAnnouncementGroup() (domain.AnnouncementGroup, error) {
+	var group domain.AnnouncementGroup
+	var updatedAt time.Time
+	err := s.db.QueryRow(`SELECT chat_id, title, updated_at FROM announcement_group WHERE id = 1`).
+		Scan(&group.ChatID, &group.Title, &updatedAt)
+	if err == sql.ErrNoRows {
+		return group, domain.ErrNoAnnouncementGroup
+	}
+	if err != nil {
+		return group, err
+	}
+	group.UpdatedAt = updatedAt.Format(time.RFC3339)
+	return group, nil
+}
+
+// SetAnnouncementGroup replaces the group the daily pairing is posted to
+func (s *PairRepositoryImpl) SetAnnouncementGroup(chatId int64, title string) (domain.AnnouncementGroup, error) {
+	_, err := s.db.Exec(`
+	INSERT INTO announcement_group (id, chat_id, title)
+	VALUES (1, $1, $2)
+	ON CONFLICT (id) DO UPDATE SET chat_id = EXCLUDED.chat_id, title = EXCLUDED.title, updated_at = NOW()`,
+		chatId, title)
+	if err != nil {
+		return domain.AnnouncementGroup{}, fmt.Errorf("failed to save announcement group: %w", err)
+	}
+	return s.GetAnnouncementGroup()
+}
+
+func (s *PairRepositoryImpl) ClearAnnouncementGroup() error {
+	_, err := s.db.Exec(`DELETE FROM announcement_group`)
 	return err
 }
+
+// publishParticipation tells the other members of the pair about the change,
+// and notifies them when their partner confirmed
+func (s *PairRepositoryImpl) publishParticipation(pairId string, userId int64, participating bool) {
+	var user1, user2 int64
+	var user3 sql.NullInt64
+	var username1, username2, username3 sql.NullString
+	err := s.db.QueryRow(`SELECT user1id, user2id, user3id, username1, username2, username3 FROM pairs WHERE id = $1`, pairId).
+		Scan(&user1, &user2, &user3, &username1, &username2, &username3)
+	if err != nil {
+		log.Printf("failed to load members of pair %s: %v\n", pairId, err)
+		return
+	}
+	event := domain.Event{Type: domain.EventParticipationUpdated, Payload: map[string]interface{}{
+		"pairId":        pairId,
+		"userId":        userId,
+		"participating": participating,
+	}}
+	members := map[int64]string{user1: username1.String, user2: username2.String}
+	if user3.Int64 != 0 {
+		members[user3.Int64] = username3.String
+	}
+	for member := range members {
+		if member == 0 || member == userId {
+			continue
+		}
+		s.events.Publish(member, event)
+		if !participating {
+			continue
+		}
+		_, err := s.notifier.Notify(domain.Notification{
+			RecipientID: member,
+			Type:        domain.NotificationPartnerConfirmed,
+			Message:     fmt.Sprintf("✅ %s is in for today's conversation!", util.Mention(members[userId])),
+			Payload:     map[string]interface{}{"pairId": pairId, "userId": userId},
+		})
+		if err != nil {
+			log.Printf("failed to notify %d about pair %s: %v\n", member, pairId, err)
+		}
+	}
+}
@@ -3,301 +3,835 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"lingo-backend/domain"
 	services "lingo-backend/service"
 	util "lingo-backend/utils"
+	"log"
+	"math"
+	"slices"
+	"sort"
 	"strconv"
 	"time"
 
 	"cloud.google.com/go/firestore"
 	"firebase.google.com/go/v4/db"
-	"github.com/lib/pq"
+	"google.golang.org/api/iterator"
 )
 
 type UserRepoImpl struct {
 	db         *sql.DB
 	firestore  *firestore.Client
 	rtdbClient *db.Client
+	events     domain.EventPublisher
+	notifier   domain.Notifier
+	createChat ChatCreator
 }
 
-func NewUserRepo(db *sql.DB, firestore *firestore.Client, rtdbClient *db.Client) *UserRepoImpl {
+// ChatCreator opens the Realtime DB chat of a match
+type ChatCreator func(ctx context.Context, chatId, name, lastMessage, systemMessage string, members []services.ChatMember) error
+
+func NewUserRepo(db *sql.DB, firestore *firestore.Client, rtdbClient *db.Client, events domain.EventPublisher, notifier domain.Notifier) *UserRepoImpl {
 	return &UserRepoImpl{
 		db:         db,
 		firestore:  firestore,
 		rtdbClient: rtdbClient,
+		events:     events,
+		notifier:   notifier,
+		createChat: func(ctx context.Context, chatId, name, lastMessage, systemMessage string, members []services.ChatMember) error {
+			return services.CreateChat(ctx, rtdbClient, chatId, name, lastMessage, systemMessage, members)
+		},
 	}
 }
 
-func (r *UserRepoImpl) FillAttendance(userIds []int64) error {
-	ctx := context.Background()
+// WithChatCreator replaces how match chats are opened, e.g. so tests run
+// without the Realtime DB
+func (r *UserRepoImpl) WithChatCreator(createChat ChatCreator) *UserRepoImpl {
+	r.createChat = createChat
+	return r
+}
 
-	// Update status in your SQL DB
-	query := "UPDATE pairs SET status = 'completed' WHERE user1id = ANY($1) OR user2id = ANY($1) OR user3id = ANY($1)"
-	_, err := r.db.Exec(query, pq.Int64Array(userIds))
+func (r *UserRepoImpl) FillAttendance(pairId string, userIds []int64) error {
+	// Only the pair being scored is completed, not every pair the users were ever in
+	_, err := r.db.Exec("UPDATE pairs SET status = 'completed' WHERE id = $1", pairId)
 	if err != nil {
 		return err
 	}
 
-	// Get yesterday's date string in YYYY-MM-DD format
-	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-
 	for _, userId := range userIds {
-		docID := strconv.FormatInt(userId, 10)
-
-		_, err := r.firestore.Collection("users").Doc(docID).Update(ctx, []firestore.Update{
-			{Path: "attendance", Value: firestore.Increment(1)},
-		})
-		if err != nil {
+		if err := r.recordAttendance(pairId, userId, true); err != nil {
 			return err
 		}
-
-		consistencyDoc := r.firestore.Collection("consistency").Doc(docID).Collection("dates").Doc(yesterday)
-		_, err = consistencyDoc.Set(ctx, map[string]interface{}{
-			"score": 1,
-		}, firestore.MergeAll)
 	}
 
 	return nil
 }
 
-func (r *UserRepoImpl) MissAttendance(userId int64) error {
+func (r *UserRepoImpl) MissAttendance(pairId string, userId int64) error {
+	return r.recordAttendance(pairId, userId, false)
+}
+
+// recordAttendance writes the user's outcome for the pair date into the
+// attendance ledger and mirrors it to Firestore. The ledger is the source of
+// truth: recording the same outcome twice doesn't touch the counters, and
+// flipping an outcome moves the count across instead of adding a new one.
+func (r *UserRepoImpl) recordAttendance(pairId string, userId int64, attended bool) error {
 	ctx := context.Background()
-	docID := strconv.FormatInt(userId, 10)
-	_, err := r.firestore.Collection("users").Doc(docID).Update(ctx, []firestore.Update{
-		{Path: "missCount", Value: firestore.Increment(1)},
-	})
+	timezone := r.userTimezone(ctx, strconv.FormatInt(userId, 10))
+
+	date, err := r.pairDate(ctx, pairId, userId)
+	if err != nil {
+		return err
+	}
 
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Get yesterday's date string in YYYY-MM-DD format
-	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-	consistencyDoc := r.firestore.Collection("consistency").Doc(docID).Collection("dates").Doc(yesterday)
-	_, err = consistencyDoc.Set(ctx, map[string]interface{}{
-		"score": 0,
+	previous, err := writeAttendance(ctx, tx, userId, pairId, date, timezone, attended)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return r.mirrorAttendance(ctx, userId, date, previous, attended)
+}
+
+// pairDate is the rotation day the pair belongs to. The ledger is keyed on it
+// rather than on when the row was created, so a rematch lands on the day it
+// replaces and rotations never share a date in any timezone.
+func (r *UserRepoImpl) pairDate(ctx context.Context, pairId string, userId int64) (string, error) {
+	var date string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT to_char(date, 'YYYY-MM-DD')
+		FROM pairs
+		WHERE id = $1 AND $2 IN (user1id, user2id, user3id)`, pairId, userId).Scan(&date)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("user %d is not in pair %s", userId, pairId)
+	} else if err != nil {
+		return "", fmt.Errorf("failed to resolve pair date: %w", err)
+	}
+	return date, nil
+}
+
+// writeAttendance records the outcome in the ledger within tx and returns the
+// outcome it replaced, if any
+func writeAttendance(ctx context.Context, tx *sql.Tx, userId int64, pairId, date, timezone string, attended bool) (sql.NullBool, error) {
+	var previous sql.NullBool
+	err := tx.QueryRowContext(ctx, `SELECT attended FROM attendance WHERE userid = $1 AND date = $2 FOR UPDATE`, userId, date).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return previous, fmt.Errorf("failed to read attendance: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO attendance (userid, date, pair_id, attended, timezone)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (userid, date) DO UPDATE SET
+			pair_id = EXCLUDED.pair_id,
+			attended = EXCLUDED.attended,
+			timezone = EXCLUDED.timezone,
+			recorded_at = NOW()`, userId, date, pairId, attended, timezone)
+	if err != nil {
+		return previous, fmt.Errorf("failed to record attendance: %w", err)
+	}
+	return previous, nil
+}
+
+// mirrorAttendance applies a committed ledger write to the Firestore counters
+// and consistency dates. A failed write can be repaired by recalculating.
+func (r *UserRepoImpl) mirrorAttendance(ctx context.Context, userId int64, date string, previous sql.NullBool, attended bool) error {
+	docID := strconv.FormatInt(userId, 10)
+
+	var updates []firestore.Update
+	switch {
+	case !previous.Valid && attended:
+		updates = append(updates, firestore.Update{Path: "attendance", Value: firestore.Increment(1)})
+	case !previous.Valid:
+		updates = append(updates, firestore.Update{Path: "missCount", Value: firestore.Increment(1)})
+	case previous.Bool != attended && attended:
+		updates = append(updates,
+			firestore.Update{Path: "attendance", Value: firestore.Increment(1)},
+			firestore.Update{Path: "missCount", Value: firestore.Increment(-1)})
+	case previous.Bool != attended:
+		updates = append(updates,
+			firestore.Update{Path: "attendance", Value: firestore.Increment(-1)},
+			firestore.Update{Path: "missCount", Value: firestore.Increment(1)})
+	}
+	if len(updates) > 0 {
+		if _, err := r.firestore.Collection("users").Doc(docID).Update(ctx, updates); err != nil {
+			return err
+		}
+	}
+
+	score := 0
+	if attended {
+		score = 1
+	}
+	consistencyDoc := r.firestore.Collection("consistency").Doc(docID).Collection("dates").Doc(date)
+	_, err := consistencyDoc.Set(ctx, map[string]interface{}{
+		"score": score,
 	}, firestore.MergeAll)
+	return err
+}
 
-	return nil
+// userTimezone reads the user's timezone from their profile, falling back to the default
+func (r *UserRepoImpl) userTimezone(ctx context.Context, docID string) string {
+	snap, err := r.firestore.Collection("users").Doc(docID).Get(ctx)
+	if err != nil || !snap.Exists() {
+		return util.DefaultTimezone()
+	}
+	tz, _ := snap.Data()["timezone"].(string)
+	return util.ResolveTimezone(tz)
+}
+
+// waitingUser is a row of the waitlist claimed inside a matching transaction
+type waitingUser struct {
+	id         int64
+	userId     int64
+	username   string
+	profileUrl string
+	prefs      domain.MatchPreferences
+	waited     time.Duration
 }
 
-func (r *UserRepoImpl) PairUser(userId int64, username, profileUrl string) (util.PairResponse, error) {
+func (r *UserRepoImpl) PairUser(userId int64, username, profileUrl string, prefs domain.MatchPreferences) (util.PairResponse, error) {
 	ctx := context.Background()
 
-	// Check if someone is already waiting
-	var waitlistId, otherUserId int64
-	var otherUsername, otherProfileUrl string
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return util.PairResponse{}, err
+	}
+	defer tx.Rollback()
+
+	// Matching is serialized, otherwise two requests could claim the same
+	// waiting user, or both find the waitlist empty and wait for each other
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, waitlistLockKey); err != nil {
+		return util.PairResponse{}, fmt.Errorf("failed to lock waitlist: %w", err)
+	}
 
-	row := r.db.QueryRow("SELECT id, userid, username, profileurl FROM waitlist ORDER BY createdAt LIMIT 1")
-	err := row.Scan(&waitlistId, &otherUserId, &otherUsername, &otherProfileUrl)
-	if err == sql.ErrNoRows {
-		// No one waiting, insert this user into waitlist
-		_, err := r.db.Exec("INSERT INTO waitlist (userid, username, profileurl) VALUES ($1, $2, $3)", userId, username, profileUrl)
-		return util.PairResponse{Wait: true}, err
-	} else if err != nil {
-		return util.PairResponse{}, fmt.Errorf("failed to query waitlist: %w", err)
+	waiting, err := loadWaitlist(ctx, tx)
+	if err != nil {
+		return util.PairResponse{}, err
 	}
-	if otherUserId == userId {
+
+	var others []waitingUser
+	var requests []services.WaitingRequest
+	for _, w := range waiting {
+		if w.userId == userId {
+			// Already waiting, the matcher job will pick them up as criteria widen
+			return util.PairResponse{Wait: true}, nil
+		}
+		others = append(others, w)
+		requests = append(requests, services.WaitingRequest{UserID: w.userId, Preferences: w.prefs, Waited: w.waited})
+	}
+
+	best := services.BestMatch(prefs, 0, requests)
+	if best < 0 {
+		// No one compatible is waiting, insert this user into waitlist
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO waitlist (userid, username, profileurl, language, level, session_length, topic)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, 0), NULLIF($7, ''))
+			ON CONFLICT (userid) DO NOTHING`,
+			userId, username, profileUrl, prefs.Language, prefs.Level, prefs.SessionLength, prefs.Topic)
+		if err != nil {
+			return util.PairResponse{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return util.PairResponse{}, err
+		}
+		r.events.Publish(userId, domain.Event{Type: domain.EventWaitlistStatus, Payload: map[string]bool{"waiting": true}})
 		return util.PairResponse{Wait: true}, nil
 	}
-	//  Found someone! Remove them from waitlist
-	_, err = r.db.Exec("DELETE FROM waitlist WHERE id = $1", waitlistId)
-	if err != nil {
+
+	other := others[best]
+	me := waitingUser{userId: userId, username: username, profileUrl: profileUrl, prefs: prefs}
+	if err := claimMatch(ctx, tx, other, me); err != nil {
+		return util.PairResponse{}, err
+	}
+	if err := tx.Commit(); err != nil {
 		return util.PairResponse{}, err
 	}
 
-	//  Create chatId based on sorted user IDs
-	var chatId string
-	if userId < otherUserId {
-		chatId = fmt.Sprintf("%d_%d", userId, otherUserId)
-	} else {
-		chatId = fmt.Sprintf("%d_%d", otherUserId, userId)
+	if err := r.completeMatch(ctx, me, other); err != nil {
+		return util.PairResponse{}, err
 	}
+	return util.PairResponse{Wait: false}, nil
+}
 
-	//  Prepare chat data
-	participants := []struct {
-		ID         int64
-		Username   string
-		ProfileURL string
-	}{
-		{ID: userId, Username: username, ProfileURL: profileUrl},
-		{ID: otherUserId, Username: otherUsername, ProfileURL: otherProfileUrl},
+// MatchWaitingUsers pairs up users who are already waiting once their
+// criteria have widened enough, so nobody depends on a new request arriving.
+func (r *UserRepoImpl) MatchWaitingUsers() (int, error) {
+	ctx := context.Background()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
 	}
+	defer tx.Rollback()
 
-	var usernames []string
-	var ids []int64
-	var images []string
-	unreadCounts := map[string]int{}
-	for _, u := range participants {
-		usernames = append(usernames, u.Username)
-		ids = append(ids, u.ID)
-		images = append(images, u.ProfileURL)
-		unreadCounts[fmt.Sprintf("%d", u.ID)] = 1
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, waitlistLockKey); err != nil {
+		return 0, fmt.Errorf("failed to lock waitlist: %w", err)
+	}
+	waiting, err := loadWaitlist(ctx, tx)
+	if err != nil {
+		return 0, err
 	}
 
-	chatData := map[string]interface{}{
-		"name":                 "Daily Match",
-		"isGroup":              false,
-		"participantUsernames": usernames,
-		"participantIds":       ids,
-		"participantImages":    images,
-		"lastMessage":          "As per your request, you have been paired. Please check your messages.",
-		"lastMessageTime":      time.Now().UnixMilli(),
-		"seenBy":               []string{},
-		"unreadCounts":         unreadCounts,
+	// Oldest first, each one takes their best partner among those still unmatched
+	var matches [][2]waitingUser
+	matched := map[int64]bool{}
+	for i, w := range waiting {
+		if matched[w.id] {
+			continue
+		}
+		var candidates []waitingUser
+		var requests []services.WaitingRequest
+		for _, o := range waiting[i+1:] {
+			if matched[o.id] {
+				continue
+			}
+			candidates = append(candidates, o)
+			requests = append(requests, services.WaitingRequest{UserID: o.userId, Preferences: o.prefs, Waited: o.waited})
+		}
+		best := services.BestMatch(w.prefs, w.waited, requests)
+		if best < 0 {
+			continue
+		}
+		other := candidates[best]
+		if err := claimMatch(ctx, tx, w, other); err != nil {
+			return 0, err
+		}
+		matched[w.id], matched[other.id] = true, true
+		matches = append(matches, [2]waitingUser{other, w})
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
 
-	// Write to Firebase Realtime DB
-	chatRef := r.rtdbClient.NewRef("chats/" + chatId)
-	if err := chatRef.Set(ctx, chatData); err != nil {
-		return util.PairResponse{}, fmt.Errorf("failed to write chat: %w", err)
+	// The claims are committed, so one failed match mustn't hold up the others
+	completed := 0
+	for _, m := range matches {
+		if err := r.completeMatch(ctx, m[0], m[1]); err != nil {
+			log.Printf("failed to complete match of %d and %d: %v\n", m[0].userId, m[1].userId, err)
+			continue
+		}
+		completed++
 	}
+	return completed, nil
+}
 
-	// Add system message to messages
-	message := map[string]interface{}{
-		"text":            "You've been paired for today's conversation!",
-		"senderName":      "admin",
-		"senderId":        1,
-		"createdAt":       time.Now().UnixMilli(),
-		"seenBy":          []string{},
-		"isSystemMessage": true,
-		"isParticipating": []string{},
+func loadWaitlist(ctx context.Context, tx *sql.Tx) ([]waitingUser, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, userid, username, COALESCE(profileurl, ''),
+			COALESCE(language, ''), COALESCE(level, ''), COALESCE(session_length, 0), COALESCE(topic, ''),
+			EXTRACT(EPOCH FROM NOW() - createdAt)::bigint
+		FROM waitlist
+		ORDER BY createdAt
+		FOR UPDATE`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query waitlist: %w", err)
 	}
+	defer rows.Close()
 
-	msgRef, err := r.rtdbClient.NewRef("messages/"+chatId).Push(ctx, nil)
+	var waiting []waitingUser
+	for rows.Next() {
+		var w waitingUser
+		var waitedSeconds int64
+		if err := rows.Scan(&w.id, &w.userId, &w.username, &w.profileUrl,
+			&w.prefs.Language, &w.prefs.Level, &w.prefs.SessionLength, &w.prefs.Topic, &waitedSeconds); err != nil {
+			return nil, err
+		}
+		w.waited = time.Duration(waitedSeconds) * time.Second
+		waiting = append(waiting, w)
+	}
+	return waiting, rows.Err()
+}
+
+// claimMatch takes the waiting users off the waitlist and logs the match,
+// the first one being whoever waited the longest
+func claimMatch(ctx context.Context, tx *sql.Tx, longest waitingUser, others ...waitingUser) error {
+	for _, w := range append([]waitingUser{longest}, others...) {
+		if w.id == 0 {
+			continue // the requester, never made it to the waitlist
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM waitlist WHERE id = $1`, w.id); err != nil {
+			return err
+		}
+	}
+	partner := longest.userId
+	if len(others) > 0 {
+		partner = others[0].userId
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO match_log (user1id, user2id, waited_seconds) VALUES ($1, $2, $3)`,
+		longest.userId, partner, int64(longest.waited.Seconds()))
 	if err != nil {
-		return util.PairResponse{}, fmt.Errorf("failed to create message reference: %w", err)
+		return fmt.Errorf("failed to log match: %w", err)
 	}
-	if err := msgRef.Set(ctx, message); err != nil {
-		return util.PairResponse{}, fmt.Errorf("failed to push system message: %w", err)
+	return nil
+}
+
+// completeMatch creates the chat for two matched users and lets them know
+func (r *UserRepoImpl) completeMatch(ctx context.Context, a, b waitingUser) error {
+	chatId := services.ChatID(a.userId, b.userId)
+	members := []services.ChatMember{
+		{ID: a.userId, Username: a.username, ProfileURL: a.profileUrl},
+		{ID: b.userId, Username: b.username, ProfileURL: b.profileUrl},
+	}
+	err := r.createChat(ctx, chatId, "Daily Match",
+		"As per your request, you have been paired. Please check your messages.",
+		"You've been paired for today's conversation!", members)
+	if err != nil {
+		// Nobody has heard of the match yet, so put both back in line
+		r.requeue(ctx, a, b)
+		return err
 	}
+	for _, pair := range [][2]waitingUser{{a, b}, {b, a}} {
+		me, partner := pair[0], pair[1]
+		_, err := r.notifier.Notify(domain.Notification{
+			RecipientID: me.userId,
+			Type:        domain.NotificationMatch,
+			Message:     "You've been paired for today's conversation!",
+			Payload:     map[string]interface{}{"chatId": chatId, "partnerId": partner.userId},
+		})
+		if err != nil {
+			return err
+		}
+		r.events.Publish(me.userId, domain.Event{Type: domain.EventMatchFound, Payload: map[string]interface{}{
+			"chatId": chatId,
+			"partner": map[string]interface{}{
+				"id":         partner.userId,
+				"username":   partner.username,
+				"profileUrl": partner.profileUrl,
+			},
+		}})
+		r.events.Publish(me.userId, domain.Event{Type: domain.EventWaitlistStatus, Payload: map[string]bool{"waiting": false}})
+	}
+	return nil
+}
 
-	//  Link chat to each user's chat list
-	for _, u := range participants {
-		userChatPath := fmt.Sprintf("userChats/%d/%s", u.ID, chatId)
-		if err := r.rtdbClient.NewRef(userChatPath).Set(ctx, true); err != nil {
-			return util.PairResponse{}, fmt.Errorf("failed to link chat to user %d: %w", u.ID, err)
+// requeue puts users whose match fell through back on the waitlist, keeping
+// their place in line
+func (r *UserRepoImpl) requeue(ctx context.Context, users ...waitingUser) {
+	for _, w := range users {
+		_, err := r.db.ExecContext(ctx, `
+			INSERT INTO waitlist (userid, username, profileurl, language, level, session_length, topic, createdAt)
+			VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, 0), NULLIF($7, ''), NOW() - make_interval(secs => $8))
+			ON CONFLICT (userid) DO NOTHING`,
+			w.userId, w.username, w.profileUrl, w.prefs.Language, w.prefs.Level, w.prefs.SessionLength, w.prefs.Topic, w.waited.Seconds())
+		if err != nil {
+			log.Printf("failed to put %d back on the waitlist: %v\n", w.userId, err)
 		}
 	}
-	// we will be emitting through websocket here and also save the notification in notifications table
-	que := "INSERT INTO notifications (id, user1id, user2id, message, createdat) VALUES ($1, $2, $3, $4, $5)"
-	_, err = r.db.Exec(que, chatId, userId, otherUserId, "You've been paired for today's conversation!", time.Now())
+}
+
+func (r *UserRepoImpl) CancelPairRequest(userId int64) error {
+	res, err := r.db.Exec(`DELETE FROM waitlist WHERE userid = $1`, userId)
 	if err != nil {
-		return util.PairResponse{}, fmt.Errorf("failed to insert notification: %w", err)
+		return fmt.Errorf("failed to leave waitlist: %w", err)
 	}
-	return util.PairResponse{Wait: false}, nil
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrNotWaiting
+	}
+	r.events.Publish(userId, domain.Event{Type: domain.EventWaitlistStatus, Payload: map[string]bool{"waiting": false, "cancelled": true}})
+	return nil
+}
+
+// GetWaitlistStatus reports the user's place in line. The estimate assumes
+// the line keeps moving at the rate of matches seen in the last window.
+func (r *UserRepoImpl) GetWaitlistStatus(userId int64) (domain.WaitlistStatus, error) {
+	var status domain.WaitlistStatus
+	err := r.db.QueryRow(`
+		SELECT w.createdAt, (SELECT COUNT(*) FROM waitlist w2 WHERE w2.createdAt <= w.createdAt)
+		FROM waitlist w WHERE w.userid = $1`, userId).Scan(&status.WaitingSince, &status.Position)
+	if err == sql.ErrNoRows {
+		return status, nil
+	}
+	if err != nil {
+		return status, fmt.Errorf("failed to read waitlist position: %w", err)
+	}
+	status.Waiting = true
+
+	var matches int64
+	err = r.db.QueryRow(`SELECT COUNT(*) FROM match_log WHERE matched_at > NOW() - make_interval(secs => $1)`,
+		services.MatchRateWindow.Seconds()).Scan(&matches)
+	if err != nil {
+		return status, fmt.Errorf("failed to read match rate: %w", err)
+	}
+	if matches > 0 {
+		estimate := int64(services.MatchRateWindow.Seconds()) * status.Position / matches
+		status.EstimatedWaitSeconds = &estimate
+	}
+	return status, nil
 }
 
-func (r *UserRepoImpl) GetNotifications(userId int64) (domain.NotificationResponse, error) {
+// GetNotifications returns a page of the user's notifications, newest first.
+// Pass the previous page's NextCursor as cursor to get the next page.
+func (r *UserRepoImpl) GetNotifications(userId int64, cursor int64, limit int) (domain.NotificationResponse, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if cursor <= 0 {
+		cursor = math.MaxInt64
+	}
 	query := `
-SELECT 
-    n.id,
-    n.user1id,
-    n.user2id,
-    n.message,
-    n.createdat,
-    CASE 
-        WHEN ns.userId IS NULL THEN false
-        ELSE true
-    END AS seen
-FROM notifications n
-LEFT JOIN notification_seen ns 
-    ON n.id = ns.notificationId AND ns.userId = $1
-WHERE n.user1id = $1 OR n.user2id = $1
-ORDER BY n.createdat DESC;
+SELECT id, recipient_id, type, message, payload, read_at, created_at
+FROM notifications
+WHERE recipient_id = $1 AND id < $2
+ORDER BY id DESC
+LIMIT $3;
 `
-	rows, err := r.db.Query(query, userId)
+	rows, err := r.db.Query(query, userId, cursor, limit)
 	if err != nil {
 		return domain.NotificationResponse{}, fmt.Errorf("failed to query notifications: %w", err)
 	}
 	defer rows.Close()
 
-	var notifications []domain.Notificaion
+	notifications := []domain.Notification{}
 	for rows.Next() {
-		var notification domain.Notificaion
+		var notification domain.Notification
+		var payload []byte
+		var readAt sql.NullString
 		if err := rows.Scan(
 			&notification.ID,
-			&notification.User1ID,
-			&notification.User2ID,
+			&notification.RecipientID,
+			&notification.Type,
 			&notification.Message,
+			&payload,
+			&readAt,
 			&notification.CreatedAt,
-			&notification.Seen,
 		); err != nil {
 			return domain.NotificationResponse{}, fmt.Errorf("failed to scan notification: %w", err)
 		}
+		if err := json.Unmarshal(payload, &notification.Payload); err != nil {
+			return domain.NotificationResponse{}, fmt.Errorf("failed to decode notification payload: %w", err)
+		}
+		if readAt.Valid {
+			notification.Read = true
+			notification.ReadAt = &readAt.String
+		}
 		notifications = append(notifications, notification)
 	}
 	if err := rows.Err(); err != nil {
 		return domain.NotificationResponse{}, fmt.Errorf("error iterating over notifications: %w", err)
 	}
-	query = `SELECT COUNT(*) FROM waitlist WHERE userid = $1`
-	var isWaiting bool
-	err = r.db.QueryRow(query, userId).Scan(&isWaiting)
+
+	response := domain.NotificationResponse{Notifications: notifications}
+	if len(notifications) == limit {
+		next := notifications[len(notifications)-1].ID
+		response.NextCursor = &next
+	}
+	if response.UnreadCount, err = r.GetUnreadCount(userId); err != nil {
+		return domain.NotificationResponse{}, err
+	}
+
+	query = `SELECT EXISTS (SELECT 1 FROM waitlist WHERE userid = $1)`
+	err = r.db.QueryRow(query, userId).Scan(&response.IsWaiting)
 	if err != nil {
 		return domain.NotificationResponse{}, fmt.Errorf("failed to check if user is waiting: %w", err)
 	}
-	return domain.NotificationResponse{Notifications: notifications, IsWaiting: isWaiting}, nil
+	return response, nil
 }
 
+func (r *UserRepoImpl) GetUnreadCount(userId int64) (int64, error) {
+	var count int64
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM notifications WHERE recipient_id = $1 AND read_at IS NULL`, userId).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	return count, nil
+}
+
+// SeenNotification marks all of the user's notifications as read
 func (r *UserRepoImpl) SeenNotification(userId int64) error {
-	query := `INSERT INTO notification_seen (notificationId, userId)
-SELECT id, $1 FROM notifications
-WHERE user1id = $1 OR user2id = $1
-ON CONFLICT (notificationId, userId) DO NOTHING;`
-	_, err := r.db.Exec(query, userId)
+	_, err := r.MarkNotificationsReadBefore(userId, math.MaxInt64)
+	return err
+}
+
+func (r *UserRepoImpl) MarkNotificationRead(userId int64, notificationId int64) error {
+	res, err := r.db.Exec(`
+		UPDATE notifications SET read_at = COALESCE(read_at, NOW())
+		WHERE id = $1 AND recipient_id = $2`, notificationId, userId)
 	if err != nil {
 		return fmt.Errorf("failed to mark notification as seen: %w", err)
 	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return domain.ErrNotificationNotFound
+	}
 	return nil
 }
 
+// MarkNotificationsReadBefore marks every unread notification up to and including before as read
+func (r *UserRepoImpl) MarkNotificationsReadBefore(userId int64, before int64) (int64, error) {
+	res, err := r.db.Exec(`
+		UPDATE notifications SET read_at = NOW()
+		WHERE recipient_id = $1 AND id <= $2 AND read_at IS NULL`, userId, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark notifications as seen: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// BroadcastNotification sends an admin message to every user. A failed
+// recipient is counted and skipped, the others still get the message.
+func (r *UserRepoImpl) BroadcastNotification(message string, payload map[string]interface{}) (int, int, error) {
+	ctx := context.Background()
+	iter := r.firestore.Collection("users").Documents(ctx)
+	defer iter.Stop()
+
+	sent, failed := 0, 0
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return sent, failed, fmt.Errorf("failed to list users: %w", err)
+		}
+		userId, err := strconv.ParseInt(doc.Ref.ID, 10, 64)
+		if err != nil {
+			continue
+		}
+		_, err = r.notifier.Notify(domain.Notification{
+			RecipientID: userId,
+			Type:        domain.NotificationAdminBroadcast,
+			Message:     message,
+			Payload:     payload,
+		})
+		if err != nil {
+			log.Printf("failed to broadcast to %d: %v\n", userId, err)
+			failed++
+			continue
+		}
+		sent++
+	}
+	return sent, failed, nil
+}
+
+// GetNotificationSettings returns the user's delivery settings, defaults included
+func (r *UserRepoImpl) GetNotificationSettings(userId int64) (domain.NotificationSettings, error) {
+	return services.LoadNotificationSettings(r.db, userId)
+}
+
+// UpdateNotificationSettings saves the user's delivery settings. Types that
+// aren't listed keep their current preference. Quiet hours without a timezone
+// use the one from the user's profile.
+func (r *UserRepoImpl) UpdateNotificationSettings(userId int64, settings domain.NotificationSettings) (domain.NotificationSettings, error) {
+	if settings.Digest == "" {
+		settings.Digest = domain.DigestOff
+	}
+	if err := services.ValidateNotificationSettings(settings); err != nil {
+		return settings, err
+	}
+	if settings.Timezone == "" {
+		settings.Timezone = r.userTimezone(context.Background(), strconv.FormatInt(userId, 10))
+	}
+
+	var quietStart, quietEnd sql.NullString
+	if settings.QuietHours != nil {
+		quietStart = sql.NullString{String: settings.QuietHours.Start, Valid: true}
+		quietEnd = sql.NullString{String: settings.QuietHours.End, Valid: true}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return settings, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	INSERT INTO notification_settings (userid, in_app, websocket, telegram, quiet_start, quiet_end, timezone, digest)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	ON CONFLICT (userid) DO UPDATE SET
+		in_app = EXCLUDED.in_app, websocket = EXCLUDED.websocket, telegram = EXCLUDED.telegram,
+		quiet_start = EXCLUDED.quiet_start, quiet_end = EXCLUDED.quiet_end,
+		timezone = EXCLUDED.timezone, digest = EXCLUDED.digest, updated_at = NOW()`,
+		userId, settings.InApp, settings.WebSocket, settings.Telegram, quietStart, quietEnd, settings.Timezone, settings.Digest)
+	if err != nil {
+		return settings, fmt.Errorf("failed to save notification settings: %w", err)
+	}
+	if err := saveNotificationPreferences(tx, userId, settings.Types); err != nil {
+		return settings, err
+	}
+	if err := tx.Commit(); err != nil {
+		return settings, err
+	}
+	return services.LoadNotificationSettings(r.db, userId)
+}
+
+// ResetNotificationSettings drops everything the user set, back to defaults
+func (r *UserRepoImpl) ResetNotificationSettings(userId int64) (domain.NotificationSettings, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return domain.NotificationSettings{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM notification_settings WHERE userid = $1`, userId); err != nil {
+		return domain.NotificationSettings{}, fmt.Errorf("failed to reset notification settings: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM notification_preferences WHERE userid = $1`, userId); err != nil {
+		return domain.NotificationSettings{}, fmt.Errorf("failed to reset notification preferences: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return domain.NotificationSettings{}, err
+	}
+	return services.LoadNotificationSettings(r.db, userId)
+}
+
+// GetNotificationPreferences returns the preference of every notification type
+func (r *UserRepoImpl) GetNotificationPreferences(userId int64) ([]domain.NotificationPreference, error) {
+	return services.LoadNotificationPreferences(r.db, userId)
+}
+
+// UpdateNotificationPreferences saves the given types, others are left as they are
+func (r *UserRepoImpl) UpdateNotificationPreferences(userId int64, prefs []domain.NotificationPreference) ([]domain.NotificationPreference, error) {
+	for _, pref := range prefs {
+		if !slices.Contains(domain.NotificationTypes, pref.Type) {
+			return nil, fmt.Errorf("%w: %q", domain.ErrUnknownNotificationType, pref.Type)
+		}
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := saveNotificationPreferences(tx, userId, prefs); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return services.LoadNotificationPreferences(r.db, userId)
+}
+
+func saveNotificationPreferences(tx *sql.Tx, userId int64, prefs []domain.NotificationPreference) error {
+	for _, pref := range prefs {
+		_, err := tx.Exec(`
+		INSERT INTO notification_preferences (userid, type, enabled, telegram)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (userid, type) DO UPDATE SET enabled = EXCLUDED.enabled, telegram = EXCLUDED.telegram, updated_at = NOW()`,
+			userId, pref.Type, pref.Enabled, pref.Telegram)
+		if err != nil {
+			return fmt.Errorf("failed to save notification preference: %w", err)
+		}
+	}
+	return nil
+}
+
+// maxReplayedEvents caps how much history a reconnecting client gets at once
+const maxReplayedEvents = 500
+
+// GetEvents returns the user's logged events after afterId, oldest first
+func (r *UserRepoImpl) GetEvents(userId int64, afterId int64) ([]domain.Event, error) {
+	rows, err := r.db.Query(`
+		SELECT id, userid, type, payload, created_at
+		FROM user_events
+		WHERE userid = $1 AND id > $2
+		ORDER BY id
+		LIMIT $3`, userId, afterId, maxReplayedEvents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []domain.Event
+	for rows.Next() {
+		var event domain.Event
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Type, &payload, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.Payload = json.RawMessage(payload)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
 func (r *UserRepoImpl) GeneratePair() (string, error) {
 	// STEP 1: Fetch all pending pairs
-	rows, err := r.db.Query(`SELECT id FROM pairs WHERE status = 'pending'`)
+	rows, err := r.db.Query(`SELECT id, to_char(date, 'YYYY-MM-DD') FROM pairs WHERE status = 'pending'`)
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch pending pairs: %w", err)
 	}
 	defer rows.Close()
 
+	pairDates := map[string]string{}
 	var pairIDs []string
 	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
+		var id, date string
+		if err := rows.Scan(&id, &date); err != nil {
 			return "", err
 		}
 		pairIDs = append(pairIDs, id)
+		pairDates[id] = date
 	}
 
 	// STEP 2: Check participation
 	for _, pairID := range pairIDs {
 		rows2, err := r.db.Query(`
-			SELECT userid, is_participating FROM pair_participation 
-			WHERE pair_id = $1
+			SELECT userid, is_participating FROM pair_participation
+			WHERE pair_id = $1 AND rematched_to IS NULL -- rematched members are scored in their new pair
 		`, pairID)
 		if err != nil {
 			return "", fmt.Errorf("error checking participation for pair %s: %w", pairID, err)
 		}
-		defer rows2.Close()
 
+		participation := map[int64]bool{}
 		for rows2.Next() {
 			var userID int64
 			var isParticipating sql.NullBool
 			if err := rows2.Scan(&userID, &isParticipating); err != nil {
+				rows2.Close()
 				return "", err
 			}
+			participation[userID] = isParticipating.Valid && isParticipating.Bool
+		}
+		rows2.Close()
+
+		confirmations, err := r.sessionConfirmations(pairID, pairDates[pairID])
+		if err != nil {
+			return "", err
+		}
 
-			if isParticipating.Valid && isParticipating.Bool {
-				r.FillAttendance([]int64{userID})
+		for userID, outcome := range sessionOutcomes(participation, confirmations) {
+			switch outcome {
+			case outcomeAttended:
+				if err := r.FillAttendance(pairID, []int64{userID}); err != nil {
+					log.Printf("failed to fill attendance for user %d in pair %s: %v\n", userID, pairID, err)
+					continue
+				}
 				fmt.Printf("✅ User %d attended in pair %s\n", userID, pairID)
-			} else {
-				r.MissAttendance(userID)
+			case outcomeDisputed:
+				if err := r.flagDispute(pairID, pairDates[pairID], userID); err != nil {
+					log.Printf("failed to flag dispute for user %d in pair %s: %v\n", userID, pairID, err)
+					continue
+				}
+				fmt.Printf("⚠️ User %d attendance disputed in pair %s\n", userID, pairID)
+			default:
+				if err := r.MissAttendance(pairID, userID); err != nil {
+					log.Printf("failed to miss attendance for user %d in pair %s: %v\n", userID, pairID, err)
+					continue
+				}
 				fmt.Printf("❌ User %d missed in pair %s\n", userID, pairID)
 			}
 		}
 	}
 
-	// STEP 3: Delete all old data (cleanup)
+	// STEP 3: Delete all old data (cleanup), reports behind open disputes stay for review
+	_, err = r.db.Exec(`DELETE FROM session_confirmations sc
+		WHERE NOT EXISTS (
+			SELECT 1 FROM attendance_disputes d
+			WHERE d.status = 'open' AND d.pair_id = sc.pair_id AND d.pair_date = sc.date
+		)`)
+	if err != nil {
+		return "", fmt.Errorf("failed to clear session_confirmations: %w", err)
+	}
 	_, err = r.db.Exec(`DELETE FROM pair_participation`)
 	if err != nil {
 		return "", fmt.Errorf("failed to clear pair_participation: %w", err)
@@ -307,24 +841,359 @@ func (r *UserRepoImpl) GeneratePair() (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to clear pairs: %w", err)
 	}
+	_, err = r.db.Exec(`DELETE FROM rematch_queue`)
+	if err != nil {
+		return "", fmt.Errorf("failed to clear rematch_queue: %w", err)
+	}
 	_, err = r.db.Exec(`DELETE FROM waitlist`)
 	if err != nil {
 		return "", fmt.Errorf("failed to clear waitlist: %w", err)
 	}
-	_, err = r.db.Exec(`DELETE FROM notification_seen`)
+	_, err = r.db.Exec(`DELETE FROM notifications WHERE created_at < NOW() - INTERVAL '30 days'`)
 	if err != nil {
-		return "", fmt.Errorf("failed to clear notification_seen: %w", err)
+		return "", fmt.Errorf("failed to clear notifications: %w", err)
 	}
-	_, err = r.db.Exec(`DELETE FROM notifications`)
+	_, err = r.db.Exec(`DELETE FROM pair_announcements`)
 	if err != nil {
-		return "", fmt.Errorf("failed to clear notifications: %w", err)
+		return "", fmt.Errorf("failed to clear pair_announcements: %w", err)
+	}
+	_, err = r.db.Exec(`DELETE FROM pair_history WHERE date < CURRENT_DATE - 60`)
+	if err != nil {
+		return "", fmt.Errorf("failed to clear pair_history: %w", err)
+	}
+	_, err = r.db.Exec(`DELETE FROM digest_runs WHERE period_start < CURRENT_DATE - 60`)
+	if err != nil {
+		return "", fmt.Errorf("failed to clear digest_runs: %w", err)
 	}
 
 	// STEP 4: Generate new pairs
-	message, err := services.GenerateDailyPairs(r.db, r.rtdbClient)
+	message, err := services.GenerateDailyPairs(r.db, r.rtdbClient, r.events, r.notifier)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate daily pairs: %w", err)
 	}
 
 	return message, nil
 }
+
+// RecalculateAttendance rebuilds every user's counters and consistency dates
+// from the attendance ledger, the ledger is never written. Consistency dates
+// the ledger has no entry for are removed. Unless apply is set nothing is
+// written and the diffs only show what would change.
+func (r *UserRepoImpl) RecalculateAttendance(apply bool) ([]domain.AttendanceRecalculation, error) {
+	ctx := context.Background()
+
+	ledger, err := r.loadAttendanceLedger(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := r.firestore.Collection("users").Documents(ctx)
+	defer iter.Stop()
+
+	var diffs []domain.AttendanceRecalculation
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list users: %w", err)
+		}
+		userId, err := strconv.ParseInt(doc.Ref.ID, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		scores, err := r.loadConsistencyScores(ctx, doc.Ref.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		entries := ledger[userId]
+		if entries == nil {
+			entries = map[string]bool{}
+		}
+		diff := domain.AttendanceRecalculation{
+			UserID:      userId,
+			Consistency: map[string]domain.ScoreChange{},
+		}
+		for date := range scores {
+			if _, ok := entries[date]; !ok {
+				diff.Removed = append(diff.Removed, date)
+			}
+		}
+		sort.Strings(diff.Removed)
+
+		for date, attended := range entries {
+			var expected int64
+			if attended {
+				expected = 1
+				diff.Attendance.Expected++
+			} else {
+				diff.MissCount.Expected++
+			}
+			current, ok := scores[date]
+			if !ok {
+				diff.Consistency[date] = domain.ScoreChange{Current: nil, Expected: expected}
+			} else if current != expected {
+				diff.Consistency[date] = domain.ScoreChange{Current: &current, Expected: expected}
+			}
+		}
+		diff.Attendance.Current = safeInt64(doc.Data()["attendance"])
+		diff.MissCount.Current = safeInt64(doc.Data()["missCount"])
+
+		if diff.Attendance.Current == diff.Attendance.Expected &&
+			diff.MissCount.Current == diff.MissCount.Expected &&
+			len(diff.Consistency) == 0 && len(diff.Removed) == 0 {
+			continue
+		}
+		diffs = append(diffs, diff)
+
+		if apply {
+			if err := r.applyRecalculation(ctx, diff); err != nil {
+				return diffs, fmt.Errorf("failed to apply recalculation for user %d: %w", userId, err)
+			}
+			log.Printf("♻️ Attendance recalculated for user %d\n", userId)
+		}
+	}
+
+	return diffs, nil
+}
+
+func (r *UserRepoImpl) loadAttendanceLedger(ctx context.Context) (map[int64]map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT userid, to_char(date, 'YYYY-MM-DD'), attended FROM attendance`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attendance ledger: %w", err)
+	}
+	defer rows.Close()
+
+	ledger := map[int64]map[string]bool{}
+	for rows.Next() {
+		var entry domain.Attendance
+		if err := rows.Scan(&entry.UserID, &entry.Date, &entry.Attended); err != nil {
+			return nil, err
+		}
+		if ledger[entry.UserID] == nil {
+			ledger[entry.UserID] = map[string]bool{}
+		}
+		ledger[entry.UserID][entry.Date] = entry.Attended
+	}
+	return ledger, rows.Err()
+}
+
+func (r *UserRepoImpl) loadConsistencyScores(ctx context.Context, docID string) (map[string]int64, error) {
+	iter := r.firestore.Collection("consistency").Doc(docID).Collection("dates").Documents(ctx)
+	defer iter.Stop()
+
+	scores := map[string]int64{}
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read consistency for user %s: %w", docID, err)
+		}
+		scores[doc.Ref.ID] = safeInt64(doc.Data()["score"])
+	}
+	return scores, nil
+}
+
+func (r *UserRepoImpl) applyRecalculation(ctx context.Context, diff domain.AttendanceRecalculation) error {
+	docID := strconv.FormatInt(diff.UserID, 10)
+	_, err := r.firestore.Collection("users").Doc(docID).Update(ctx, []firestore.Update{
+		{Path: "attendance", Value: diff.Attendance.Expected},
+		{Path: "missCount", Value: diff.MissCount.Expected},
+	})
+	if err != nil {
+		return err
+	}
+
+	for date, change := range diff.Consistency {
+		consistencyDoc := r.firestore.Collection("consistency").Doc(docID).Collection("dates").Doc(date)
+		if _, err := consistencyDoc.Set(ctx, map[string]interface{}{
+			"score": change.Expected,
+		}, firestore.MergeAll); err != nil {
+			return err
+		}
+	}
+	for _, date := range diff.Removed {
+		if _, err := r.firestore.Collection("consistency").Doc(docID).Collection("dates").Doc(date).Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sessionOutcome int
+
+const (
+	outcomeMissed sessionOutcome = iota
+	outcomeAttended
+	outcomeDisputed
+)
+
+// sessionOutcomes decides attendance for every member of a pair. A member is
+// credited only when they and at least one other member confirmed each other.
+// A no-show report is contested, and becomes a dispute, when the reported
+// member says the session happened or another member vouches for them.
+func sessionOutcomes(participation map[int64]bool, confirmations []domain.SessionConfirmation) map[int64]sessionOutcome {
+	type report struct{ reporter, subject int64 }
+	reports := map[report]bool{}
+	for _, c := range confirmations {
+		reports[report{c.ReporterID, c.SubjectID}] = c.ShowedUp
+	}
+
+	outcomes := map[int64]sessionOutcome{}
+	for userID, participating := range participation {
+		if !participating {
+			outcomes[userID] = outcomeMissed
+			continue
+		}
+
+		var mutual, vouched, reportedMissing, claimsSession bool
+		for other := range participation {
+			if other == userID {
+				continue
+			}
+			showedUp, reportedOn := reports[report{other, userID}]
+			sawOther, reportedOther := reports[report{userID, other}]
+			if reportedOn && !showedUp {
+				reportedMissing = true
+			}
+			if reportedOn && showedUp {
+				vouched = true
+			}
+			if reportedOther && sawOther {
+				claimsSession = true
+				if reportedOn && showedUp {
+					mutual = true
+				}
+			}
+		}
+
+		switch {
+		case reportedMissing && (claimsSession || vouched):
+			outcomes[userID] = outcomeDisputed
+		case mutual && !reportedMissing:
+			outcomes[userID] = outcomeAttended
+		default:
+			outcomes[userID] = outcomeMissed
+		}
+	}
+	return outcomes
+}
+
+// sessionConfirmations returns the reports on the pair's session of the given date
+func (r *UserRepoImpl) sessionConfirmations(pairId, date string) ([]domain.SessionConfirmation, error) {
+	rows, err := r.db.Query(`
+		SELECT pair_id, to_char(date, 'YYYY-MM-DD'), reporter_id, subject_id, showed_up, created_at
+		FROM session_confirmations WHERE pair_id = $1 AND date = $2
+		ORDER BY created_at`, pairId, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read confirmations for pair %s: %w", pairId, err)
+	}
+	defer rows.Close()
+
+	var confirmations []domain.SessionConfirmation
+	for rows.Next() {
+		var c domain.SessionConfirmation
+		if err := rows.Scan(&c.PairID, &c.Date, &c.ReporterID, &c.SubjectID, &c.ShowedUp, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		confirmations = append(confirmations, c)
+	}
+	return confirmations, rows.Err()
+}
+
+// flagDispute holds back the user's attendance for the pair until an admin
+// resolves it. The pair date is captured now since pairs don't survive the rotation.
+func (r *UserRepoImpl) flagDispute(pairId, pairDate string, userId int64) error {
+	ctx := context.Background()
+	timezone := r.userTimezone(ctx, strconv.FormatInt(userId, 10))
+	// the ledger is keyed on the pair date too, so both columns hold it
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO attendance_disputes (pair_id, pair_date, userid, date, timezone)
+		VALUES ($1, $2, $3, $2, $4)
+		ON CONFLICT (pair_id, pair_date, userid) DO NOTHING`, pairId, pairDate, userId, timezone)
+	return err
+}
+
+func (r *UserRepoImpl) GetDisputes(status string) ([]domain.AttendanceDispute, error) {
+	if status == "" {
+		status = "open"
+	}
+	rows, err := r.db.Query(`
+		SELECT id, pair_id, to_char(pair_date, 'YYYY-MM-DD'), userid, to_char(date, 'YYYY-MM-DD'), status, attended, created_at, resolved_at
+		FROM attendance_disputes
+		WHERE status = $1
+		ORDER BY created_at`, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query disputes: %w", err)
+	}
+	defer rows.Close()
+
+	disputes := []domain.AttendanceDispute{}
+	for rows.Next() {
+		var d domain.AttendanceDispute
+		var attended sql.NullBool
+		var resolvedAt sql.NullString
+		if err := rows.Scan(&d.ID, &d.PairID, &d.PairDate, &d.UserID, &d.Date, &d.Status, &attended, &d.CreatedAt, &resolvedAt); err != nil {
+			return nil, err
+		}
+		if attended.Valid {
+			d.Attended = &attended.Bool
+		}
+		if resolvedAt.Valid {
+			d.ResolvedAt = &resolvedAt.String
+		}
+		disputes = append(disputes, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range disputes {
+		reports, err := r.sessionConfirmations(disputes[i].PairID, disputes[i].PairDate)
+		if err != nil {
+			return nil, err
+		}
+		disputes[i].Reports = reports
+	}
+	return disputes, nil
+}
+
+// ResolveDispute records the admin's decision in the attendance ledger
+func (r *UserRepoImpl) ResolveDispute(disputeId int64, attended bool) error {
+	ctx := context.Background()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var pairId, date, timezone string
+	var userId int64
+	err = tx.QueryRowContext(ctx, `
+		UPDATE attendance_disputes
+		SET status = 'resolved', attended = $2, resolved_at = NOW()
+		WHERE id = $1 AND status = 'open'
+		RETURNING pair_id, userid, to_char(date, 'YYYY-MM-DD'), timezone`, disputeId, attended).Scan(&pairId, &userId, &date, &timezone)
+	if err == sql.ErrNoRows {
+		return domain.ErrDisputeNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	previous, err := writeAttendance(ctx, tx, userId, pairId, date, timezone, attended)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	// Only once the dispute and the ledger agree
+	return r.mirrorAttendance(ctx, userId, date, previous, attended)
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"lingo-backend/db/dbtest"
+	services "lingo-backend/service"
+)
+
+func TestAttendanceKeyedOnPairDate(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := &UserRepoImpl{db: db}
+	ctx := context.Background()
+
+	// created in the evening and the night of the same day in Los Angeles,
+	// but they belong to consecutive rotations
+	_, err := db.Exec(`
+		INSERT INTO pairs (id, user1id, user2id, username1, username2, date, created_at) VALUES
+		('pair-18', 1, 2, 'one', 'two', '2026-10-18', '2026-10-19 01:00'),
+		('pair-19', 1, 3, 'one', 'three', '2026-10-19', '2026-10-19 06:30')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a rematch is created whenever the pair it replaces fell apart
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveRematch(ctx, tx, "rematch-20", "2026-10-20", sql.NullTime{},
+		services.ChatMember{ID: 1, Username: "one"}, services.ChatMember{ID: 4, Username: "four"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	const timezone = "America/Los_Angeles"
+	for _, pairId := range []string{"pair-18", "pair-19", "rematch-20"} {
+		date, err := repo.pairDate(ctx, pairId, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		previous, err := writeAttendance(ctx, tx, 1, pairId, date, timezone, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if previous.Valid {
+			t.Errorf("%s overwrote the entry of %s", pairId, date)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT to_char(date, 'YYYY-MM-DD'), pair_id FROM attendance WHERE userid = 1 ORDER BY date`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var date, pairId string
+		if err := rows.Scan(&date, &pairId); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, date+" "+pairId)
+	}
+	want := []string{"2026-10-18 pair-18", "2026-10-19 pair-19", "2026-10-20 rematch-20"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("ledger = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// TelegramClient is the part of the Bot API the bot subsystem uses. The
+// tgbotapi implementation talks to Telegram, the tests use FakeTelegramClient.
+type TelegramClient interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	// MakeRequest calls methods tgbotapi has no config for, e.g. setWebhook with a secret token
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+	GetUserProfilePhotos(config tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error)
+	GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error)
+	DownloadFile(file tgbotapi.File) (io.ReadCloser, error)
+	GetWebhookInfo() (tgbotapi.WebhookInfo, error)
+	// BotUsername is the bot's own username, commands meant for it may carry it as /cmd@username
+	BotUsername() string
+	// Updates long polls for updates until StopUpdates is called
+	Updates(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
+	StopUpdates()
+}
+
+type botAPIClient struct {
+	*tgbotapi.BotAPI
+}
+
+// NewBotAPIClient connects to Telegram with the given bot token
+func NewBotAPIClient(token string) (TelegramClient, error) {
+	api, err := tgbotapi.NewBotAPI(token)
+	if err != nil {
+		return nil, err
+	}
+	api.Debug = true // For logging
+	return botAPIClient{api}, nil
+}
+
+// ConnectTelegram connects with BOT_TOKEN, retrying until Telegram answers
+func ConnectTelegram() TelegramClient {
+	for {
+		client, err := NewBotAPIClient(os.Getenv("BOT_TOKEN"))
+		if err == nil {
+			return client
+		}
+		log.Println(" Telegram bot connection failed, retrying in 10s:", err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+// DownloadFile fetches a file's content, the download URL embeds the bot
+// token so it never leaves this type
+func (c botAPIClient) DownloadFile(file tgbotapi.File) (io.ReadCloser, error) {
+	resp, err := http.Get(file.Link(c.Token))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download of %s failed with status %d", file.FilePath, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func (c botAPIClient) BotUsername() string {
+	return c.Self.UserName
+}
+
+func (c botAPIClient) Updates(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	return c.GetUpdatesChan(config)
+}
+
+func (c botAPIClient) StopUpdates() {
+	c.StopReceivingUpdates()
+}
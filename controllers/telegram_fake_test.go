@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// FakeTelegramClient is an in-memory TelegramClient for running the bot
+// offline. It records everything sent, serves the profile photos and files
+// it is given, and delivers updates pushed with PushUpdate.
+type FakeTelegramClient struct {
+	mu sync.Mutex
+
+	Sent     []tgbotapi.Chattable
+	Requests []tgbotapi.Chattable
+	Photos   map[int64]tgbotapi.UserProfilePhotos // by user ID
+	Files    map[string]tgbotapi.File             // by file ID
+	Contents map[string][]byte                    // by file path
+	Webhook  tgbotapi.WebhookInfo
+	Username string // the bot's username
+
+	// SendErr, when set, is returned by Send instead of sending
+	SendErr error
+
+	nextMessageID int
+	updates       chan tgbotapi.Update
+}
+
+func NewFakeTelegramClient() *FakeTelegramClient {
+	return &FakeTelegramClient{
+		Photos:   map[int64]tgbotapi.UserProfilePhotos{},
+		Files:    map[string]tgbotapi.File{},
+		Contents: map[string][]byte{},
+		Username: "lingo_bot",
+		updates:  make(chan tgbotapi.Update, 100),
+	}
+}
+
+func (f *FakeTelegramClient) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.SendErr != nil {
+		return tgbotapi.Message{}, f.SendErr
+	}
+	f.Sent = append(f.Sent, c)
+	f.nextMessageID++
+
+	msg := tgbotapi.Message{MessageID: f.nextMessageID}
+	switch m := c.(type) {
+	case tgbotapi.MessageConfig:
+		msg.Chat = &tgbotapi.Chat{ID: m.ChatID}
+		msg.Text = m.Text
+	case tgbotapi.EditMessageTextConfig:
+		msg.MessageID = m.MessageID
+		msg.Chat = &tgbotapi.Chat{ID: m.ChatID}
+		msg.Text = m.Text
+	}
+	return msg, nil
+}
+
+func (f *FakeTelegramClient) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Requests = append(f.Requests, c)
+	if _, ok := c.(tgbotapi.DeleteWebhookConfig); ok {
+		f.Webhook = tgbotapi.WebhookInfo{}
+	}
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (f *FakeTelegramClient) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if endpoint == "setWebhook" {
+		f.Webhook = tgbotapi.WebhookInfo{URL: params["url"]}
+	}
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (f *FakeTelegramClient) GetUserProfilePhotos(config tgbotapi.UserProfilePhotosConfig) (tgbotapi.UserProfilePhotos, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Photos[config.UserID], nil
+}
+
+func (f *FakeTelegramClient) GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, ok := f.Files[config.FileID]
+	if !ok {
+		return file, fmt.Errorf("file %s not found", config.FileID)
+	}
+	return file, nil
+}
+
+func (f *FakeTelegramClient) DownloadFile(file tgbotapi.File) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.Contents[file.FilePath]
+	if !ok {
+		return nil, errors.New("file content not found")
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (f *FakeTelegramClient) GetWebhookInfo() (tgbotapi.WebhookInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Webhook, nil
+}
+
+func (f *FakeTelegramClient) BotUsername() string {
+	return f.Username
+}
+
+func (f *FakeTelegramClient) Updates(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	return f.updates
+}
+
+func (f *FakeTelegramClient) StopUpdates() {
+	close(f.updates)
+}
+
+// PushUpdate delivers an update as if a user had sent it
+func (f *FakeTelegramClient) PushUpdate(update tgbotapi.Update) {
+	f.updates <- update
+}
+
+// SentTexts returns the text of every message sent so far
+func (f *FakeTelegramClient) SentTexts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, c := range f.Sent {
+		switch m := c.(type) {
+		case tgbotapi.MessageConfig:
+			texts = append(texts, m.Text)
+		case tgbotapi.EditMessageTextConfig:
+			texts = append(texts, m.Text)
+		}
+	}
+	return texts
+}
@@ -0,0 +1,344 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	domain "lingo-backend/domain"
+
+	"cloud.google.com/go/firestore"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	globalSendRate     = 30 // Telegram allows about 30 messages a second
+	perChatSendRate    = 1  // and one a second to the same chat
+	maxSendAttempts    = 5
+	sendRetryBase      = 10 * time.Second // doubled after every failed attempt
+	outboxBatchSize    = 50
+	outboxPollInterval = time.Second
+	outboxClaimLease   = time.Minute
+)
+
+// tokenBucket allows rate sends a second with bursts of up to burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// take uses up a token when one is available, otherwise it returns how long
+// until the next one is
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled, so it can be forgotten
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+type outgoingMessage struct {
+	id       int64
+	chatID   int64
+	text     string
+	pairID   string
+	attempts int
+}
+
+// ThrottledSender queues bot messages in the bot_outbox table and sends them
+// from a single worker, throttled with a global and a per chat token bucket
+// to stay under Telegram's rate limits. Messages rejected with 429 are
+// retried after the retry_after Telegram asks for. The buckets are per
+// replica, running several replicas multiplies the rate.
+type ThrottledSender struct {
+	db         *sql.DB
+	firestore  *firestore.Client
+	announcer  *PairAnnouncer
+	wake       chan struct{}
+	global     *tokenBucket           // only touched by Run
+	chats      map[int64]*tokenBucket // only touched by Run
+	pauseUntil time.Time
+}
+
+func NewThrottledSender(db *sql.DB, firestoreClient *firestore.Client, announcer *PairAnnouncer) *ThrottledSender {
+	return &ThrottledSender{
+		db:        db,
+		firestore: firestoreClient,
+		announcer: announcer,
+		wake:      make(chan struct{}, 1),
+		global:    newTokenBucket(globalSendRate, globalSendRate),
+		chats:     map[int64]*tokenBucket{},
+	}
+}
+
+// Send queues a message, it doesn't wait for Telegram
+func (s *ThrottledSender) Send(chatID int64, text string) error {
+	return s.enqueue(chatID, text, "", time.Time{})
+}
+
+// SendNotification queues a notification for the recipient's chat. Pair
+// assignments become an announcement with participation buttons. It isn't
+// sent before notBefore, e.g. the end of the recipient's quiet hours.
+func (s *ThrottledSender) SendNotification(notification domain.Notification, notBefore time.Time) error {
+	var pairID string
+	if notification.Type == domain.NotificationPairAssigned {
+		pairID, _ = notification.Payload["pairId"].(string)
+	}
+	return s.enqueue(notification.RecipientID, notification.Message, pairID, notBefore)
+}
+
+// enqueue stores a message in the outbox, a zero notBefore sends it right away
+func (s *ThrottledSender) enqueue(chatID int64, text, pairID string, notBefore time.Time) error {
+	at := sql.NullTime{Time: notBefore, Valid: !notBefore.IsZero()}
+	_, err := s.db.Exec(`
+		INSERT INTO bot_outbox (chat_id, text, pair_id, not_before)
+		VALUES ($1, $2, NULLIF($3, ''), COALESCE($4::timestamptz, NOW()))`,
+		chatID, text, pairID, at)
+	if err != nil {
+		return err
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends queued messages until ctx is cancelled
+func (s *ThrottledSender) Run(ctx context.Context) {
+	for {
+		sent, err := s.sendBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println("failed to send from the bot outbox:", err)
+		}
+		if sent > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(outboxPollInterval):
+		}
+	}
+}
+
+// sendBatch claims the messages that are due and sends them, returning how
+// many were sent. Messages left unsent on shutdown are picked up again once
+// their claim runs out.
+func (s *ThrottledSender) sendBatch(ctx context.Context) (int, error) {
+	if time.Now().Before(s.pauseUntil) {
+		return 0, nil // held by a 429, the messages wait in the outbox
+	}
+	msgs, err := s.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for i, msg := range msgs {
+		// Telegram's retry_after can outlast the claim, so the rest of the
+		// batch is handed back instead of waiting for it with the claim held
+		if wait := time.Until(s.pauseUntil); wait > 0 {
+			for _, rest := range msgs[i:] {
+				s.reschedule(rest, wait)
+			}
+			break
+		}
+		bucket, ok := s.chats[msg.chatID]
+		if !ok {
+			bucket = newTokenBucket(perChatSendRate, 1)
+			s.chats[msg.chatID] = bucket
+		}
+		if wait := bucket.take(time.Now()); wait > 0 {
+			s.reschedule(msg, wait)
+			continue
+		}
+		if err := s.waitForGlobal(ctx); err != nil {
+			return sent, err
+		}
+		s.deliver(msg)
+		sent++
+	}
+
+	now := time.Now()
+	for chatID, bucket := range s.chats {
+		if bucket.full(now) {
+			delete(s.chats, chatID)
+		}
+	}
+	return sent, nil
+}
+
+func (s *ThrottledSender) claim(ctx context.Context) ([]outgoingMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE bot_outbox SET claimed_until = NOW() + $1 * INTERVAL '1 second'
+		WHERE id IN (
+			SELECT id FROM bot_outbox
+			WHERE not_before <= NOW() AND (claimed_until IS NULL OR claimed_until < NOW())
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, chat_id, text, COALESCE(pair_id, ''), attempts`,
+		outboxClaimLease.Seconds(), outboxBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []outgoingMessage
+	for rows.Next() {
+		var msg outgoingMessage
+		if err := rows.Scan(&msg.id, &msg.chatID, &msg.text, &msg.pairID, &msg.attempts); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// RETURNING doesn't keep the subquery's order
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].id < msgs[j].id })
+	return msgs, nil
+}
+
+// waitForGlobal blocks until the global bucket has a token, which is at most
+// a fraction of a second
+func (s *ThrottledSender) waitForGlobal(ctx context.Context) error {
+	for {
+		wait := s.global.take(time.Now())
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *ThrottledSender) deliver(msg outgoingMessage) {
+	if wait, retry := s.attempt(&msg); retry {
+		s.reschedule(msg, wait)
+		return
+	}
+	s.finish(msg)
+}
+
+// attempt sends the message once and reports whether, and after how long, it
+// should be tried again
+func (s *ThrottledSender) attempt(msg *outgoingMessage) (time.Duration, bool) {
+	var err error
+	if msg.pairID != "" {
+		err = s.announcer.Announce(msg.chatID, msg.pairID)
+	} else {
+		err = SendMessage(msg.chatID, msg.text)
+	}
+	if err == nil {
+		return 0, false
+	}
+
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+		// flood control applies to the whole bot, so hold every send. The
+		// message wasn't at fault, so this isn't counted as an attempt.
+		wait := time.Duration(tgErr.RetryAfter) * time.Second
+		s.pauseUntil = time.Now().Add(wait)
+		return wait, true
+	}
+
+	msg.attempts++
+	switch {
+	case unreachable(err):
+		log.Printf("user %d can't be reached anymore: %v\n", msg.chatID, err)
+		s.markInactive(msg.chatID, err)
+		return 0, false
+	case errors.As(err, &tgErr) && tgErr.Code >= 400 && tgErr.Code < 500:
+		// Telegram refused the message itself, sending it again won't help
+		log.Printf("Telegram rejected message to %d: %v\n", msg.chatID, err)
+		return 0, false
+	case msg.attempts >= maxSendAttempts:
+		log.Printf("giving up on message to %d after %d attempts: %v\n", msg.chatID, msg.attempts, err)
+		return 0, false
+	default:
+		// network errors, Telegram 5xx and the bot not being connected yet pass
+		wait := sendRetryBackoff(msg.attempts)
+		log.Printf("failed to send message to %d, retrying in %s: %v\n", msg.chatID, wait, err)
+		return wait, true
+	}
+}
+
+// sendRetryBackoff is how long to wait after the given number of failed
+// attempts, doubling each time
+func sendRetryBackoff(attempts int) time.Duration {
+	return sendRetryBase << (attempts - 1)
+}
+
+// finish removes a message that was sent or given up on
+func (s *ThrottledSender) finish(msg outgoingMessage) {
+	if _, err := s.db.Exec(`DELETE FROM bot_outbox WHERE id = $1`, msg.id); err != nil {
+		log.Printf("failed to remove message %d from the bot outbox: %v\n", msg.id, err)
+	}
+}
+
+// reschedule releases the claim on a message and sends it once wait has passed
+func (s *ThrottledSender) reschedule(msg outgoingMessage, wait time.Duration) {
+	_, err := s.db.Exec(`
+		UPDATE bot_outbox
+		SET not_before = NOW() + $2 * INTERVAL '1 millisecond', attempts = $3, claimed_until = NULL
+		WHERE id = $1`, msg.id, wait.Milliseconds(), msg.attempts)
+	if err != nil {
+		log.Printf("failed to reschedule message %d: %v\n", msg.id, err)
+	}
+}
+
+// unreachable reports whether Telegram refused a message because the user
+// blocked the bot or deleted their account, retrying won't help
+func unreachable(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) || tgErr.Code != http.StatusForbidden {
+		return false
+	}
+	reason := strings.ToLower(tgErr.Message)
+	return strings.Contains(reason, "bot was blocked") || strings.Contains(reason, "user is deactivated")
+}
+
+// markInactive flags the profile so the daily rotation skips the user until
+// they send /start again
+func (s *ThrottledSender) markInactive(chatID int64, reason error) {
+	if chatID <= 0 {
+		return // groups and channels have negative IDs, they aren't users
+	}
+	_, err := s.firestore.Collection("users").Doc(fmt.Sprint(chatID)).Update(context.Background(), []firestore.Update{
+		{Path: "inactive", Value: true},
+		{Path: "inactiveReason", Value: reason.Error()},
+		{Path: "inactiveSince", Value: time.Now()},
+	})
+	if err != nil && status.Code(err) != codes.NotFound {
+		log.Printf("failed to mark %d inactive: %v\n", chatID, err)
+	}
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// useClient makes the fake the running bot for the rest of the test
+func useClient(t *testing.T, client TelegramClient) {
+	t.Helper()
+	activeBotMu.Lock()
+	previous := activeBot
+	activeBot = client
+	activeBotMu.Unlock()
+	t.Cleanup(func() {
+		activeBotMu.Lock()
+		activeBot = previous
+		activeBotMu.Unlock()
+	})
+}
+
+func TestAttemptRetriesTransientErrors(t *testing.T) {
+	client := NewFakeTelegramClient()
+	client.SendErr = errors.New("connection reset by peer")
+	useClient(t, client)
+	s := NewThrottledSender(nil, nil, nil)
+
+	msg := outgoingMessage{id: 1, chatID: 42, text: "hi"}
+	wantWait := sendRetryBase
+	for attempt := 1; attempt < maxSendAttempts; attempt++ {
+		wait, retry := s.attempt(&msg)
+		if !retry {
+			t.Fatalf("attempt %d: gave up on a transient error", attempt)
+		}
+		if wait != wantWait {
+			t.Errorf("attempt %d: retry in %s, want %s", attempt, wait, wantWait)
+		}
+		if msg.attempts != attempt {
+			t.Errorf("attempt %d: counted %d attempts", attempt, msg.attempts)
+		}
+		wantWait *= 2
+	}
+	if _, retry := s.attempt(&msg); retry {
+		t.Errorf("retried after %d attempts", msg.attempts)
+	}
+
+	// once the error clears the message goes out
+	client.SendErr = nil
+	msg.attempts = 0
+	if _, retry := s.attempt(&msg); retry {
+		t.Error("retried a message that was sent")
+	}
+	if texts := client.SentTexts(); len(texts) != 1 || texts[0] != "hi" {
+		t.Errorf("sent %q, want the message", texts)
+	}
+}
+
+func TestAttemptWaitsOutFloodControl(t *testing.T) {
+	client := NewFakeTelegramClient()
+	client.SendErr = &tgbotapi.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 30}}
+	useClient(t, client)
+	s := NewThrottledSender(nil, nil, nil)
+
+	msg := outgoingMessage{id: 1, chatID: 42, text: "hi", attempts: maxSendAttempts - 1}
+	wait, retry := s.attempt(&msg)
+	if !retry || wait != 30*time.Second {
+		t.Errorf("got retry %v in %s, want a retry in 30s", retry, wait)
+	}
+	if msg.attempts != maxSendAttempts-1 {
+		t.Errorf("a 429 was counted as an attempt")
+	}
+	if time.Until(s.pauseUntil) <= 0 {
+		t.Error("the sender wasn't paused")
+	}
+}
+
+func TestAttemptGivesUpOnRejectedMessages(t *testing.T) {
+	client := NewFakeTelegramClient()
+	client.SendErr = &tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}
+	useClient(t, client)
+	s := NewThrottledSender(nil, nil, nil)
+
+	msg := outgoingMessage{id: 1, chatID: 42, text: "hi"}
+	if _, retry := s.attempt(&msg); retry {
+		t.Error("retried a message Telegram rejected")
+	}
+}
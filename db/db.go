@@ -10,6 +10,23 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// ConnString builds the Postgres connection string from the environment,
+// it is also needed by pq.Listener which opens its own connection
+func ConnString() (string, error) {
+	host := os.Getenv("HOST")
+	port, err := strconv.Atoi(os.Getenv("DB_PORT"))
+	if err != nil {
+		return "", err
+	}
+	dbname := os.Getenv("DB_NAME")
+	password := os.Getenv("DB_PASSWORD")
+	user := os.Getenv("DB_USER")
+	sslmode := os.Getenv("SSL_MODE")
+	return fmt.Sprintf("host=%s port=%d user=%s "+
+		"password=%s dbname=%s sslmode=%s",
+		host, port, user, password, dbname, sslmode), nil
+}
+
 func ConnectDb() (*sql.DB, error) {
 	// err := godotenv.Load()
 	// if err != nil {
@@ -17,19 +34,11 @@ func ConnectDb() (*sql.DB, error) {
 	// 	return nil, err
 	// }
 
-	host := os.Getenv("HOST")
-	port, err := strconv.Atoi(os.Getenv("DB_PORT"))
+	psqlInfo, err := ConnString()
 	if err != nil {
 		log.Fatal("Error parsing port")
 		return nil, err
 	}
-	dbname := os.Getenv("DB_NAME")
-	password := os.Getenv("DB_PASSWORD")
-	user := os.Getenv("DB_USER")
-	sslmode := os.Getenv("SSL_MODE")
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+
-		"password=%s dbname=%s sslmode=%s",
-		host, port, user, password, dbname, sslmode)
 
 	db, err := sql.Open("postgres", psqlInfo)
 	if err != nil {
@@ -0,0 +1,10 @@
+CREATE TABLE attendance (
+    userid BIGINT NOT NULL,
+    date DATE NOT NULL, -- pair date in the user's timezone
+    pair_id VARCHAR(50) NOT NULL, -- no FK, pairs are cleared on every rotation
+    attended BOOLEAN NOT NULL,
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    recorded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (userid, date)
+);
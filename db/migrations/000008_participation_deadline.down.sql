@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS participation_deadlines;
+DROP INDEX IF EXISTS pair_participation_pair_user_idx;
+ALTER TABLE pair_participation DROP COLUMN IF EXISTS reminded_at;
+ALTER TABLE pair_participation DROP COLUMN IF EXISTS no_show;
+ALTER TABLE pairs DROP COLUMN IF EXISTS deadline;
@@ -0,0 +1,14 @@
+ALTER TABLE pairs ADD COLUMN deadline TIMESTAMPTZ;
+
+ALTER TABLE pair_participation ADD COLUMN no_show BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE pair_participation ADD COLUMN reminded_at TIMESTAMP;
+
+-- UpdatePairParticipation upserts on (pair_id, userid)
+CREATE UNIQUE INDEX IF NOT EXISTS pair_participation_pair_user_idx ON pair_participation (pair_id, userid);
+
+-- Admin overrides of the default deadline for a given pair date
+CREATE TABLE participation_deadlines (
+    date DATE PRIMARY KEY,
+    deadline TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,11 @@
+-- Members left without a partner after everyone else in their pair declined
+CREATE TABLE rematch_queue (
+    userid BIGINT NOT NULL,
+    date DATE NOT NULL,
+    pair_id VARCHAR(50) NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    profileurl VARCHAR(255),
+    createdat TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (userid, date)
+);
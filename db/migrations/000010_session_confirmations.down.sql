@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS attendance_disputes;
+DROP TABLE IF EXISTS session_confirmations;
@@ -0,0 +1,25 @@
+-- What each member says about the others after a session
+CREATE TABLE session_confirmations (
+    pair_id VARCHAR(50) NOT NULL, -- no FK, reports outlive the pair while disputed
+    reporter_id BIGINT NOT NULL,
+    subject_id BIGINT NOT NULL,
+    showed_up BOOLEAN NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (pair_id, reporter_id, subject_id)
+);
+
+-- Conflicting reports waiting for an admin decision
+CREATE TABLE attendance_disputes (
+    id SERIAL PRIMARY KEY,
+    pair_id VARCHAR(50) NOT NULL,
+    userid BIGINT NOT NULL,
+    date DATE NOT NULL, -- pair date in the user's timezone
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    status VARCHAR(20) NOT NULL DEFAULT 'open', -- open, resolved
+    attended BOOLEAN, -- admin decision
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP,
+
+    CONSTRAINT unique_dispute_per_pair UNIQUE (pair_id, userid)
+);
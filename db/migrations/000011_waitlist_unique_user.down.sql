@@ -0,0 +1 @@
+DROP INDEX IF EXISTS waitlist_userid_idx;
@@ -0,0 +1,4 @@
+-- Keep only the oldest entry per user before enforcing one entry per user
+DELETE FROM waitlist a USING waitlist b WHERE a.userid = b.userid AND a.id > b.id;
+
+CREATE UNIQUE INDEX waitlist_userid_idx ON waitlist (userid);
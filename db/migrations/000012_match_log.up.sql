@@ -0,0 +1,10 @@
+-- On-demand matches, used to estimate how long the waitlist takes to move
+CREATE TABLE match_log (
+    id SERIAL PRIMARY KEY,
+    user1id BIGINT NOT NULL,
+    user2id BIGINT NOT NULL,
+    waited_seconds BIGINT NOT NULL,
+    matched_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX match_log_matched_at_idx ON match_log (matched_at);
@@ -0,0 +1,4 @@
+ALTER TABLE waitlist DROP COLUMN IF EXISTS topic;
+ALTER TABLE waitlist DROP COLUMN IF EXISTS session_length;
+ALTER TABLE waitlist DROP COLUMN IF EXISTS level;
+ALTER TABLE waitlist DROP COLUMN IF EXISTS language;
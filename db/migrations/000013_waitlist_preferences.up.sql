@@ -0,0 +1,4 @@
+ALTER TABLE waitlist ADD COLUMN language VARCHAR(50);
+ALTER TABLE waitlist ADD COLUMN level VARCHAR(50);
+ALTER TABLE waitlist ADD COLUMN session_length INT; -- minutes
+ALTER TABLE waitlist ADD COLUMN topic VARCHAR(100);
@@ -0,0 +1,8 @@
+CREATE TABLE sessions (
+    token_hash VARCHAR(64) PRIMARY KEY, -- sha256 of the token handed to the client
+    userid BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX sessions_userid_idx ON sessions (userid);
@@ -0,0 +1,10 @@
+-- Every real-time event sent to a user, lets SSE clients resume with Last-Event-ID
+CREATE TABLE user_events (
+    id BIGSERIAL PRIMARY KEY,
+    userid BIGINT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX user_events_userid_id_idx ON user_events (userid, id);
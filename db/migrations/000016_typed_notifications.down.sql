@@ -0,0 +1,25 @@
+ALTER TABLE notifications RENAME TO notifications_v2;
+
+CREATE TABLE notifications(
+    id VARCHAR(255) PRIMARY KEY,
+    user1Id BIGINT NOT NULL,
+    user2Id BIGINT NOT NULL,
+    message TEXT NOT NULL,
+    createdAt TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE notification_seen (
+    notificationId VARCHAR(255) NOT NULL,
+    userId BIGINT NOT NULL,
+    seenAt TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (notificationId, userId),
+    FOREIGN KEY (notificationId) REFERENCES notifications(id)
+);
+
+INSERT INTO notifications (id, user1Id, user2Id, message, createdAt)
+SELECT id::text, recipient_id, recipient_id, message, created_at FROM notifications_v2;
+
+INSERT INTO notification_seen (notificationId, userId, seenAt)
+SELECT id::text, recipient_id, read_at FROM notifications_v2 WHERE read_at IS NOT NULL;
+
+DROP TABLE notifications_v2;
@@ -0,0 +1,25 @@
+-- One row per recipient, with a type, a JSON payload and its own read state
+CREATE TABLE notifications_v2 (
+    id BIGSERIAL PRIMARY KEY,
+    recipient_id BIGINT NOT NULL,
+    type VARCHAR(32) NOT NULL, -- match, reminder, streak, admin_broadcast
+    message TEXT NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    read_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Split each two-user notification into a row per user, keeping what they've seen
+INSERT INTO notifications_v2 (recipient_id, type, message, payload, read_at, created_at)
+SELECT u.userid, 'match', n.message, jsonb_build_object('chatId', n.id), ns.seenAt, n.createdAt
+FROM notifications n
+CROSS JOIN LATERAL (SELECT DISTINCT unnest(ARRAY[n.user1Id, n.user2Id]) AS userid) u
+LEFT JOIN notification_seen ns ON ns.notificationId = n.id AND ns.userId = u.userid
+ORDER BY n.createdAt;
+
+DROP TABLE notification_seen;
+DROP TABLE notifications;
+ALTER TABLE notifications_v2 RENAME TO notifications;
+
+CREATE INDEX notifications_recipient_idx ON notifications (recipient_id, id DESC);
+CREATE INDEX notifications_unread_idx ON notifications (recipient_id) WHERE read_at IS NULL;
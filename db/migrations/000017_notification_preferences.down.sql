@@ -0,0 +1,2 @@
+ALTER TABLE pair_participation DROP COLUMN IF EXISTS streak_warned_at;
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,12 @@
+-- per-type Telegram delivery, a missing row means the type's default
+CREATE TABLE notification_preferences (
+    userid BIGINT NOT NULL,
+    type VARCHAR(32) NOT NULL,
+    telegram BOOLEAN NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (userid, type)
+);
+
+-- members warned that their streak is at risk, so the warning goes out once
+ALTER TABLE pair_participation ADD COLUMN streak_warned_at TIMESTAMP;
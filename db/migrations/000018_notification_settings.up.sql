@@ -0,0 +1,14 @@
+-- per-user delivery settings, a missing row means the defaults
+CREATE TABLE notification_settings (
+    userid BIGINT PRIMARY KEY,
+    in_app BOOLEAN NOT NULL DEFAULT true,
+    websocket BOOLEAN NOT NULL DEFAULT true,
+    telegram BOOLEAN NOT NULL DEFAULT true,
+    quiet_start VARCHAR(5), -- HH:MM, both NULL when quiet hours are off
+    quiet_end VARCHAR(5),
+    timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    digest VARCHAR(10) NOT NULL DEFAULT 'off', -- off, daily, weekly
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE notification_preferences ADD COLUMN enabled BOOLEAN NOT NULL DEFAULT true;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS digest_runs;
+DROP TABLE IF EXISTS pair_history;
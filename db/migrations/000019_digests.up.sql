@@ -0,0 +1,22 @@
+-- Who was paired with whom, kept after the daily cleanup for digests
+CREATE TABLE pair_history (
+    date DATE NOT NULL,
+    pair_id VARCHAR(50) NOT NULL,
+    userid BIGINT NOT NULL,
+    partner_id BIGINT NOT NULL,
+    partner_username VARCHAR(255) NOT NULL DEFAULT '',
+
+    PRIMARY KEY (date, pair_id, userid, partner_id)
+);
+
+CREATE INDEX pair_history_user_date_idx ON pair_history (userid, date);
+
+-- One row per digest sent, so a period is only summarised once
+CREATE TABLE digest_runs (
+    userid BIGINT NOT NULL,
+    period VARCHAR(10) NOT NULL, -- daily or weekly
+    period_start DATE NOT NULL, -- local date, or the Monday of the week
+    sent_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (userid, period, period_start)
+);
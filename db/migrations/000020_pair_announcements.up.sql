@@ -0,0 +1,9 @@
+-- Telegram messages announcing a pair, edited as members answer
+CREATE TABLE pair_announcements (
+    pair_id VARCHAR(50) NOT NULL, -- no FK, pairs are cleared on every rotation
+    chat_id BIGINT NOT NULL,
+    message_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (pair_id, chat_id)
+);
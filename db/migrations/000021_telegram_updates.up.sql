@@ -0,0 +1,5 @@
+-- Updates received through the webhook, Telegram may deliver one twice
+CREATE TABLE telegram_updates (
+    update_id BIGINT PRIMARY KEY,
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
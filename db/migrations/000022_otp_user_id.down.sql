@@ -0,0 +1,4 @@
+UPDATE otp SET username = '' WHERE username IS NULL;
+ALTER TABLE otp ALTER COLUMN username SET NOT NULL;
+ALTER TABLE otp DROP CONSTRAINT IF EXISTS unique_otp_userid;
+ALTER TABLE otp ADD CONSTRAINT unique_user_otp UNIQUE (userid, username);
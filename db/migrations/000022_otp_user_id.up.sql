@@ -0,0 +1,12 @@
+-- OTPs belong to the Telegram user ID, usernames are optional and can change
+DELETE FROM otp o
+USING otp newer
+WHERE o.userid = newer.userid AND o.createdat < newer.createdat;
+
+DELETE FROM otp o
+USING otp other
+WHERE o.userid = other.userid AND o.id < other.id;
+
+ALTER TABLE otp DROP CONSTRAINT IF EXISTS unique_user_otp;
+ALTER TABLE otp ADD CONSTRAINT unique_otp_userid UNIQUE (userid);
+ALTER TABLE otp ALTER COLUMN username DROP NOT NULL;
@@ -0,0 +1,10 @@
+-- One-time login links sent by the bot, only the hash of the token is stored
+CREATE TABLE login_tokens (
+    token_hash VARCHAR(64) PRIMARY KEY,
+    userid BIGINT NOT NULL, -- Telegram user ID
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX login_tokens_userid_idx ON login_tokens (userid);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS group_announcements;
+DROP TABLE IF EXISTS announcement_group;
@@ -0,0 +1,14 @@
+-- The Telegram group the daily pairing is posted to, at most one
+CREATE TABLE announcement_group (
+    id SMALLINT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    chat_id BIGINT NOT NULL,
+    title VARCHAR(255) NOT NULL DEFAULT '',
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Pair dates already posted, so replicas post each rotation once
+CREATE TABLE group_announcements (
+    date DATE PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    sent_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
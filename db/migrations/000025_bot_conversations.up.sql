@@ -0,0 +1,7 @@
+-- Where each user is in a multi-step bot conversation, kept across restarts
+CREATE TABLE bot_conversations (
+    userid BIGINT PRIMARY KEY,
+    step VARCHAR(32) NOT NULL,
+    answers JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,13 @@
+-- Messages waiting to be sent by the bot, so they survive restarts
+CREATE TABLE bot_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    chat_id BIGINT NOT NULL,
+    text TEXT NOT NULL,
+    pair_id VARCHAR(255), -- set for pair announcements, sent with participation buttons
+    attempts INT NOT NULL DEFAULT 0,
+    not_before TIMESTAMP NOT NULL DEFAULT NOW(),
+    claimed_until TIMESTAMP, -- a replica is sending it, reclaimable once passed
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_bot_outbox_due ON bot_outbox (not_before, id);
@@ -0,0 +1 @@
+ALTER TABLE pair_participation DROP COLUMN IF EXISTS rematched_to;
@@ -0,0 +1,3 @@
+-- Set on a member's row in their original pair once they have been rematched,
+-- pointing at the pair they were moved to
+ALTER TABLE pair_participation ADD COLUMN rematched_to VARCHAR(50);
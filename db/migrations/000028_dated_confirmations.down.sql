@@ -0,0 +1,7 @@
+ALTER TABLE attendance_disputes DROP CONSTRAINT unique_dispute_per_pair;
+ALTER TABLE attendance_disputes ADD CONSTRAINT unique_dispute_per_pair UNIQUE (pair_id, userid);
+ALTER TABLE attendance_disputes DROP COLUMN IF EXISTS pair_date;
+
+ALTER TABLE session_confirmations DROP CONSTRAINT session_confirmations_pkey;
+ALTER TABLE session_confirmations ADD PRIMARY KEY (pair_id, reporter_id, subject_id);
+ALTER TABLE session_confirmations DROP COLUMN IF EXISTS date;
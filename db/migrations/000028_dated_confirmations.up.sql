@@ -0,0 +1,15 @@
+-- Pair IDs recur whenever the same members are paired again, so reports and
+-- disputes are keyed on the pair date too
+ALTER TABLE session_confirmations ADD COLUMN date DATE;
+UPDATE session_confirmations sc SET date = p.date FROM pairs p WHERE p.id = sc.pair_id;
+UPDATE session_confirmations SET date = created_at::date WHERE date IS NULL;
+ALTER TABLE session_confirmations ALTER COLUMN date SET NOT NULL;
+ALTER TABLE session_confirmations DROP CONSTRAINT session_confirmations_pkey;
+ALTER TABLE session_confirmations ADD PRIMARY KEY (pair_id, date, reporter_id, subject_id);
+
+-- date stays the pair date in the user's timezone, pair_date matches the reports
+ALTER TABLE attendance_disputes ADD COLUMN pair_date DATE;
+UPDATE attendance_disputes SET pair_date = date;
+ALTER TABLE attendance_disputes ALTER COLUMN pair_date SET NOT NULL;
+ALTER TABLE attendance_disputes DROP CONSTRAINT unique_dispute_per_pair;
+ALTER TABLE attendance_disputes ADD CONSTRAINT unique_dispute_per_pair UNIQUE (pair_id, pair_date, userid);
@@ -0,0 +1 @@
+ALTER TABLE otp DROP COLUMN failed_attempts;
@@ -0,0 +1,2 @@
+-- Wrong codes entered for the OTP, it is dropped once there are too many
+ALTER TABLE otp ADD COLUMN failed_attempts INT NOT NULL DEFAULT 0;
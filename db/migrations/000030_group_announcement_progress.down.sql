@@ -0,0 +1,3 @@
+ALTER TABLE group_announcements DROP COLUMN claimed_until;
+ALTER TABLE group_announcements DROP COLUMN finished;
+ALTER TABLE group_announcements DROP COLUMN sent_chunks;
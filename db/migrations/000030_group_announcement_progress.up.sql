@@ -0,0 +1,6 @@
+-- Chunks of the announcement already posted, a failed post resumes after them
+ALTER TABLE group_announcements ADD COLUMN sent_chunks INT NOT NULL DEFAULT 0;
+-- Announcements posted before this were complete
+ALTER TABLE group_announcements ADD COLUMN finished BOOLEAN NOT NULL DEFAULT true;
+-- A replica is posting it, reclaimable once passed
+ALTER TABLE group_announcements ADD COLUMN claimed_until TIMESTAMP;
@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// Event types pushed to connected clients
+const (
+	EventNotificationCreated  = "notification.created"
+	EventMatchFound           = "match.found"
+	EventParticipationUpdated = "participation.updated"
+	EventWaitlistStatus       = "waitlist.status"
+	EventPairCreated          = "pair.created"
+)
+
+// Event is a real-time update for a single user
+type Event struct {
+	ID        int64       `json:"id,omitempty"`
+	Type      string      `json:"type"`
+	UserID    int64       `json:"userId"`
+	Payload   interface{} `json:"payload"`
+	CreatedAt time.Time   `json:"createdAt"`
+}
+
+type EventPublisher interface {
+	Publish(userId int64, event Event)
+}
@@ -0,0 +1,90 @@
+package domain
+
+import "errors"
+
+var (
+	ErrNotificationNotFound        = errors.New("notification not found")
+	ErrUnknownNotificationType     = errors.New("unknown notification type")
+	ErrInvalidNotificationSettings = errors.New("invalid notification settings")
+)
+
+// Notification types
+const (
+	NotificationMatch            = "match"
+	NotificationReminder         = "reminder" // participation deadline approaching
+	NotificationStreak           = "streak"   // streak at risk
+	NotificationAdminBroadcast   = "admin_broadcast"
+	NotificationPairAssigned     = "pair_assigned"
+	NotificationPartnerConfirmed = "partner_confirmed"
+	NotificationDigest           = "digest"
+	NotificationWaitlistExpired  = "waitlist_expired" // no partner found in time
+)
+
+var NotificationTypes = []string{
+	NotificationMatch,
+	NotificationReminder,
+	NotificationStreak,
+	NotificationAdminBroadcast,
+	NotificationPairAssigned,
+	NotificationPartnerConfirmed,
+	NotificationDigest,
+	NotificationWaitlistExpired,
+}
+
+type Notification struct {
+	ID          int64                  `json:"id" db:"id"`
+	RecipientID int64                  `json:"recipientId" db:"recipient_id"`
+	Type        string                 `json:"type" db:"type"`
+	Message     string                 `json:"message" db:"message"`
+	Payload     map[string]interface{} `json:"payload" db:"payload"`
+	Read        bool                   `json:"read"`
+	ReadAt      *string                `json:"readAt" db:"read_at"`
+	CreatedAt   string                 `json:"createdAt" db:"created_at"`
+}
+
+type NotificationResponse struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int64          `json:"unreadCount"`
+	NextCursor    *int64         `json:"nextCursor"` // nil on the last page
+	IsWaiting     bool           `json:"isWaiting"`
+}
+
+// NotificationPreference says whether a notification type is delivered at all
+// and whether it is also sent to the user's Telegram chat
+type NotificationPreference struct {
+	Type     string `json:"type"`
+	Enabled  bool   `json:"enabled"`
+	Telegram bool   `json:"telegram"`
+}
+
+// Digest modes
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// QuietHours is a daily window, in the user's timezone, during which nothing
+// is pushed to Telegram. End before Start wraps past midnight.
+type QuietHours struct {
+	Start string `json:"start"` // HH:MM
+	End   string `json:"end"`   // HH:MM
+}
+
+// NotificationSettings controls how a user's notifications are delivered.
+// InApp stores them for the notification list, WebSocket pushes them to
+// connected clients (WebSocket and SSE) and Telegram mirrors them to the bot chat.
+type NotificationSettings struct {
+	InApp      bool                     `json:"inApp"`
+	WebSocket  bool                     `json:"websocket"`
+	Telegram   bool                     `json:"telegram"`
+	QuietHours *QuietHours              `json:"quietHours"` // nil when off
+	Timezone   string                   `json:"timezone"`
+	Digest     string                   `json:"digest"`
+	Types      []NotificationPreference `json:"types"`
+}
+
+// Notifier stores a notification for its recipient and delivers it
+type Notifier interface {
+	Notify(notification Notification) (Notification, error)
+}
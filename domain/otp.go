@@ -1,5 +1,16 @@
 package domain
 
+import "errors"
+
+var (
+	ErrInvalidSession = errors.New("invalid or expired session")
+	ErrInvalidOtp     = errors.New("Invalid OTP")
+	ErrOtpExpired     = errors.New("OTP has expired. Please request a new one.")
+	ErrOtpLocked      = errors.New("Too many wrong OTPs. Please request a new one.")
+	ErrInvalidLogin   = errors.New("login link is invalid, expired or already used")
+	ErrUnknownUser    = errors.New("no profile found, send /start to the bot first")
+)
+
 type Otp struct {
 	ID        int64  `json:"id" db:"id"`
 	UserID    int64  `json:"userId" db:"userid"`
@@ -20,5 +31,10 @@ type User struct {
 
 type OtpRepository interface {
 	SaveOtp(otp Otp) error
-	CheckOtp(username string, otp int64) (*User, error)
+	// CheckOtp logs in by Telegram user ID, or by username when userId is 0
+	CheckOtp(userId int64, username string, otp int64) (*User, error)
+	// ExchangeLoginToken redeems a one-time login link for the user it was issued to
+	ExchangeLoginToken(token string) (*User, error)
+	CreateSession(userId int64) (string, error)
+	ValidateSession(token string) (int64, error)
 }
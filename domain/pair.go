@@ -1,7 +1,21 @@
 package domain
 
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotInPair           = errors.New("user is not part of this pair")
+	ErrParticipationClosed = errors.New("the confirmation deadline for this pair has passed")
+	ErrPairRematched       = errors.New("the members of this pair have already been rematched")
+	ErrSessionNotHeld      = errors.New("the session of this pair hasn't taken place yet")
+	ErrDisputeNotFound     = errors.New("dispute not found or already resolved")
+	ErrNoAnnouncementGroup = errors.New("no announcement group is configured")
+)
+
 type Pair struct {
-	ID                 int64  `json:"id" db:"id"`
+	ID                 string `json:"id" db:"id"`
 	User1ID            int64  `json:"user1Id" db:"user1id"`
 	User2ID            int64  `json:"user2Id" db:"user2id"`
 	User3ID            int64  `json:"user3Id" db:"user3id"`
@@ -12,9 +26,52 @@ type Pair struct {
 	User2Participating bool   `json:"user2Participating" db:"user2participating"`
 	User3Participating bool   `json:"user3Participating" db:"user3participating"`
 	SpecialGroup       bool   `json:"specialGroup" db:"specialgroup"`
+	Deadline           string `json:"deadline" db:"deadline"`
+}
+
+// SessionConfirmation is one member's report on whether another member showed up
+type SessionConfirmation struct {
+	PairID     string `json:"pairId" db:"pair_id"`
+	Date       string `json:"date" db:"date"` // the pair's date
+	ReporterID int64  `json:"reporterId" db:"reporter_id"`
+	SubjectID  int64  `json:"userId" db:"subject_id"`
+	ShowedUp   bool   `json:"showedUp" db:"showed_up"`
+	CreatedAt  string `json:"createdAt" db:"created_at"`
+}
+
+// AttendanceDispute is raised when the members of a pair disagree about
+// whether someone showed up. Attendance is held back until an admin decides.
+type AttendanceDispute struct {
+	ID         int64                 `json:"id" db:"id"`
+	PairID     string                `json:"pairId" db:"pair_id"`
+	PairDate   string                `json:"pairDate" db:"pair_date"`
+	UserID     int64                 `json:"userId" db:"userid"`
+	Date       string                `json:"date" db:"date"` // in the user's timezone
+	Status     string                `json:"status" db:"status"`
+	Attended   *bool                 `json:"attended" db:"attended"`
+	Reports    []SessionConfirmation `json:"reports"`
+	CreatedAt  string                `json:"createdAt" db:"created_at"`
+	ResolvedAt *string               `json:"resolvedAt" db:"resolved_at"`
+}
+
+// AnnouncementGroup is the Telegram group the daily pairing is posted to
+type AnnouncementGroup struct {
+	ChatID    int64  `json:"chatId" db:"chat_id"`
+	Title     string `json:"title" db:"title"`
+	UpdatedAt string `json:"updatedAt" db:"updated_at"`
+}
+
+// ParticipationListener is told whenever a member answers for a pair
+type ParticipationListener interface {
+	ParticipationChanged(pairId string)
 }
 
 type PairRepository interface {
 	GetDailyPairs(userId int64) (Pair, error)
 	UpdatePairParticipation(pairId string, userId int64, participating bool) error
+	SetParticipationDeadline(date string, deadline time.Time) (int64, error)
+	ConfirmSession(pairId string, reporterId int64, reports []SessionConfirmation) error
+	GetAnnouncementGroup() (AnnouncementGroup, error)
+	SetAnnouncementGroup(chatId int64, title string) (AnnouncementGroup, error)
+	ClearAnnouncementGroup() error
 }
@@ -1,25 +1,81 @@
 package domain
 
-import util "lingo-backend/utils"
-
-type Notificaion struct {
-	ID        string `json:"id" db:"id"`
-	User1ID   int64  `json:"user1Id" db:"user1id"`
-	User2ID   int64  `json:"user2Id" db:"user2id"`
-	Message   string `json:"message" db:"message"`
-	Seen      bool   `json:"seen" db:"seen"`
-	CreatedAt string `json:"createdAt" db:"createdat"`
+import (
+	"errors"
+	util "lingo-backend/utils"
+)
+
+var ErrNotWaiting = errors.New("user is not on the waitlist")
+
+// MatchPreferences are optional hints for on-demand pairing, empty fields match anyone
+type MatchPreferences struct {
+	Language      string `json:"language"`
+	Level         string `json:"level"`
+	SessionLength int    `json:"sessionLength"` // minutes
+	Topic         string `json:"topic"`
 }
-type NotificationResponse struct {
-	Notifications []Notificaion `json:"notifications"`
-	IsWaiting     bool          `json:"isWaiting"`
+
+type WaitlistStatus struct {
+	Waiting              bool   `json:"waiting"`
+	Position             int64  `json:"position,omitempty"` // 1 is next in line
+	WaitingSince         string `json:"waitingSince,omitempty"`
+	EstimatedWaitSeconds *int64 `json:"estimatedWaitSeconds"` // nil when there were no recent matches
 }
 
 type UserRepository interface {
-	FillAttendance(userIds []int64) error
-	MissAttendance(userId int64) error
-	PairUser(userId int64, username string, profileUrl string) (util.PairResponse, error)
-	GetNotifications(userId int64) (NotificationResponse, error)
+	FillAttendance(pairId string, userIds []int64) error
+	MissAttendance(pairId string, userId int64) error
+	PairUser(userId int64, username string, profileUrl string, prefs MatchPreferences) (util.PairResponse, error)
+	MatchWaitingUsers() (int, error)
+	CancelPairRequest(userId int64) error
+	GetWaitlistStatus(userId int64) (WaitlistStatus, error)
+	GetNotifications(userId int64, cursor int64, limit int) (NotificationResponse, error)
+	GetUnreadCount(userId int64) (int64, error)
 	SeenNotification(userId int64) error
+	MarkNotificationRead(userId int64, notificationId int64) error
+	MarkNotificationsReadBefore(userId int64, before int64) (int64, error)
+	// BroadcastNotification returns how many users were notified and how many failed
+	BroadcastNotification(message string, payload map[string]interface{}) (sent int, failed int, err error)
+	GetNotificationSettings(userId int64) (NotificationSettings, error)
+	UpdateNotificationSettings(userId int64, settings NotificationSettings) (NotificationSettings, error)
+	ResetNotificationSettings(userId int64) (NotificationSettings, error)
+	GetNotificationPreferences(userId int64) ([]NotificationPreference, error)
+	UpdateNotificationPreferences(userId int64, prefs []NotificationPreference) ([]NotificationPreference, error)
+	GetEvents(userId int64, afterId int64) ([]Event, error)
 	GeneratePair() (string, error)
+	RecalculateAttendance(apply bool) ([]AttendanceRecalculation, error)
+	GetDisputes(status string) ([]AttendanceDispute, error)
+	ResolveDispute(disputeId int64, attended bool) error
+}
+
+// Attendance is one row of the attendance ledger, the source of truth for the
+// attendance/missCount counters and consistency dates kept in Firestore.
+type Attendance struct {
+	UserID     int64  `json:"userId" db:"userid"`
+	Date       string `json:"date" db:"date"`
+	PairID     string `json:"pairId" db:"pair_id"`
+	Attended   bool   `json:"attended" db:"attended"`
+	Timezone   string `json:"timezone" db:"timezone"`
+	RecordedAt string `json:"recordedAt" db:"recorded_at"`
+}
+
+// CounterChange is a Firestore counter next to the value the ledger gives.
+type CounterChange struct {
+	Current  int64 `json:"current"`
+	Expected int64 `json:"expected"`
+}
+
+type ScoreChange struct {
+	Current  *int64 `json:"current"` // nil when the consistency doc is missing
+	Expected int64  `json:"expected"`
+}
+
+// AttendanceRecalculation is the difference between what Firestore holds for a
+// user and what the attendance ledger says it should hold.
+type AttendanceRecalculation struct {
+	UserID      int64                  `json:"userId"`
+	Attendance  CounterChange          `json:"attendance"`
+	MissCount   CounterChange          `json:"missCount"`
+	Consistency map[string]ScoreChange `json:"consistency,omitempty"`
+	Removed     []string               `json:"removed,omitempty"` // consistency dates the ledger has no entry for
 }
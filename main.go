@@ -25,5 +25,7 @@ func main() {
 	}
 
 	log.Println("Running on port", port)
-	r.Run(":" + port)
+	if err := r.Run(":" + port); err != nil {
+		log.Println("Server stopped:", err)
+	}
 }
@@ -5,11 +5,16 @@ import (
 	"lingo-backend/db"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	bot "lingo-backend/controllers"
 
 	handlers "lingo-backend/controllers/handlers"
 	repository "lingo-backend/controllers/repository"
+	services "lingo-backend/service"
 	usecases "lingo-backend/usecase"
 
 	firebase "firebase.google.com/go/v4"
@@ -19,11 +24,15 @@ import (
 )
 
 type Router struct {
-	route *mux.Router
+	route      *mux.Router
+	ctx        context.Context // cancelled on shutdown, stops background work
+	cancel     context.CancelFunc
+	onShutdown []func()
 }
 
 func NewRouter(r *mux.Router) *Router {
-	return &Router{route: r}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Router{route: r, ctx: ctx, cancel: cancel}
 }
 
 func (r *Router) RegisterRoute() {
@@ -42,7 +51,7 @@ func (r *Router) RegisterRoute() {
 	// 	log.Println("Cannot connect to firestore")
 	// 	// return
 	// }
-	ctx := context.Background()
+	ctx := r.ctx
 
 	config := &firebase.Config{
 		DatabaseURL: "https://lingo-19e2a-default-rtdb.firebaseio.com/",
@@ -68,35 +77,91 @@ func (r *Router) RegisterRoute() {
 	otpUsecase := usecases.NewOtpUsecase(otpRepository)
 	otpHandler := handlers.NewOtpHandler(*otpUsecase)
 
+	// real-time events, logged so clients can resume and fanned out to every replica
+	hub := services.NewHub()
+	eventLog := services.NewEventLog(database, hub)
+	// notifications, mirrored to Telegram through a rate limited, persisted send queue
+	announcer := bot.NewPairAnnouncer(database)
+	telegramSender := bot.NewThrottledSender(database, client, announcer)
+	go telegramSender.Run(ctx)
+	notifier := services.NewNotifier(database, eventLog, telegramSender)
+	if connString, err := db.ConnString(); err == nil {
+		go func() {
+			if err := services.ListenForEvents(ctx, connString, database, hub); err != nil {
+				log.Println("Event listener stopped:", err)
+			}
+		}()
+	}
+
 	// Define route prefix
 	routes := r.route.PathPrefix("/api/v1").Subrouter()
 
 	routes.HandleFunc("/otp", otpHandler.CheckOtp).Methods("POST")
+	routes.HandleFunc("/otp/login-token", otpHandler.ExchangeLoginToken).Methods("POST")
 	routes.HandleFunc("/otp/wake-up", otpHandler.WakeUpRender).Methods("GET")
 	// pair endpoint
-	pairRepository := repository.NewPairRepository(database)
+	pairRepository := repository.NewPairRepository(database, client, rtdbClient, eventLog, notifier, announcer)
 	pairUsecase := usecases.NewPairUsecase(pairRepository)
-	pairHandler := handlers.NewPairHandler(*pairUsecase)
+	pairHandler := handlers.NewPairHandler(*pairUsecase, otpUsecase)
 
 	// Define route prefix
 	routes.HandleFunc("/pair/{userId}", pairHandler.GetDailyPairs).Methods("GET")
 	routes.HandleFunc("/pair", pairHandler.UpdatePairParticipation).Methods("PUT")
+	routes.HandleFunc("/pair/confirm", pairHandler.ConfirmSession).Methods("POST")
 
 	// user endpoint
-	userRepository := repository.NewUserRepo(database, client, rtdbClient)
+	userRepository := repository.NewUserRepo(database, client, rtdbClient, eventLog, notifier)
 	userUsecase := usecases.NewUserUsecase(userRepository)
-	userHandler := handlers.NewUserHandler(*userUsecase)
+	userHandler := handlers.NewUserHandler(*userUsecase, hub, otpUsecase)
 
-	// routes.HandleFunc("/ws", userHandler.HandleWebSocket)
+	registerUserRoutes(routes, userHandler)
 
-	routes.HandleFunc("/user/attendance", userHandler.FillAttendance).Methods("POST")
-	routes.HandleFunc("/user/pair", userHandler.PairUser).Methods("POST")
-	routes.HandleFunc("/user/notifications/{userId}", userHandler.GetNotifications).Methods("GET")
-	routes.HandleFunc("/user/seen-notification/{userId}", userHandler.SeenNotification).Methods("POST")
-	routes.HandleFunc("/user/generate-pair", userHandler.GeneratePair).Methods("POST")
+	// admin endpoints
+	routes.HandleFunc("/admin/attendance/recalculate", handlers.RequireAdmin(userHandler.RecalculateAttendance)).Methods("POST")
+	routes.HandleFunc("/admin/pair/deadline", handlers.RequireAdmin(pairHandler.SetParticipationDeadline)).Methods("PUT")
+	routes.HandleFunc("/admin/announcement-group", handlers.RequireAdmin(pairHandler.GetAnnouncementGroup)).Methods("GET")
+	routes.HandleFunc("/admin/announcement-group", handlers.RequireAdmin(pairHandler.SetAnnouncementGroup)).Methods("PUT")
+	routes.HandleFunc("/admin/announcement-group", handlers.RequireAdmin(pairHandler.ClearAnnouncementGroup)).Methods("DELETE")
+	routes.HandleFunc("/admin/notifications/broadcast", handlers.RequireAdmin(userHandler.BroadcastNotification)).Methods("POST")
+	routes.HandleFunc("/admin/disputes", handlers.RequireAdmin(userHandler.GetDisputes)).Methods("GET")
+	routes.HandleFunc("/admin/disputes/{id}/resolve", handlers.RequireAdmin(userHandler.ResolveDispute)).Methods("POST")
 
 	log.Println("Routes registered:")
-	go bot.ListenToBot(database, client)
+	telegramBot := bot.NewBot(database, client, pairUsecase, userUsecase, announcer)
+	if bot.BotMode() == bot.BotModeWebhook {
+		r.route.HandleFunc(bot.WebhookPath(), telegramBot.ServeWebhook).Methods("POST")
+	}
+	go func() { telegramBot.Listen(bot.ConnectTelegram()) }()
+	r.onShutdown = append(r.onShutdown, telegramBot.Stop)
+
+	// background jobs
+	go services.RunJobs(ctx,
+		services.Job{Name: "participation-reminders", Every: time.Minute, Run: func(ctx context.Context) error {
+			return services.SendDeadlineReminders(ctx, database, notifier)
+		}},
+		services.Job{Name: "streak-warnings", Every: time.Minute, Run: func(ctx context.Context) error {
+			return services.WarnStreaksAtRisk(ctx, database, notifier)
+		}},
+		services.Job{Name: "digests", Every: 15 * time.Minute, Run: func(ctx context.Context) error {
+			return services.SendDigests(ctx, database, notifier)
+		}},
+		services.Job{Name: "no-shows", Every: time.Minute, Run: func(ctx context.Context) error {
+			return services.MarkNoShows(ctx, database)
+		}},
+		services.Job{Name: "waitlist-expiry", Every: time.Minute, Run: func(ctx context.Context) error {
+			return services.ExpireWaitlist(ctx, database, eventLog, notifier)
+		}},
+		services.Job{Name: "waitlist-matcher", Every: 15 * time.Second, Run: func(ctx context.Context) error {
+			_, err := userUsecase.MatchWaitingUsers()
+			return err
+		}},
+		services.Job{Name: "group-announcement", Every: time.Minute, Run: announcer.AnnounceToGroup},
+		services.Job{Name: "telegram-webhook", Every: 5 * time.Minute, Run: telegramBot.EnsureWebhook},
+		services.Job{Name: "telegram-update-pruning", Every: time.Hour, Run: telegramBot.PruneUpdates},
+		services.Job{Name: "event-log-pruning", Every: time.Hour, Run: func(ctx context.Context) error {
+			return services.PruneEventLog(ctx, database)
+		}},
+	)
 
 	if err != nil {
 		log.Println("Error generating daily pairs:", err)
@@ -105,16 +170,68 @@ func (r *Router) RegisterRoute() {
 
 }
 
+// Run serves until SIGINT or SIGTERM, then lets in-flight requests finish
+// and runs the shutdown hooks
 func (r *Router) Run(addr string) error {
 
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "Last-Event-ID"},
 	})
 
 	handler := corsHandler.Handler(r.route)
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Println("Server running on port: ", addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		r.shutdown()
+		return err
+	case sig := <-stop:
+		log.Println("Shutting down on", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	r.shutdown()
+	return err
+}
+
+func (r *Router) shutdown() {
+	for _, hook := range r.onShutdown {
+		hook()
+	}
+	r.cancel()
+}
+
+// registerUserRoutes adds the user endpoints under routes
+func registerUserRoutes(routes *mux.Router, userHandler *handlers.UserHandler) {
+	routes.HandleFunc("/ws", userHandler.HandleWebSocket)
+	routes.HandleFunc("/user/{userId:[0-9]+}/events", userHandler.StreamEvents).Methods("GET")
 
-	log.Println("Server running on port: ", addr)
-	return http.ListenAndServe(addr, handler)
+	routes.HandleFunc("/user/attendance", userHandler.FillAttendance).Methods("POST")
+	routes.HandleFunc("/user/pair", userHandler.PairUser).Methods("POST")
+	routes.HandleFunc("/user/pair", userHandler.CancelPairRequest).Methods("DELETE")
+	routes.HandleFunc("/user/pair/status/{userId}", userHandler.GetWaitlistStatus).Methods("GET")
+	routes.HandleFunc("/user/notifications/{userId}", userHandler.GetNotifications).Methods("GET")
+	routes.HandleFunc("/user/notifications/{userId}/unread-count", userHandler.GetUnreadCount).Methods("GET")
+	routes.HandleFunc("/user/notifications/{userId}/read", userHandler.MarkNotificationsRead).Methods("POST")
+	routes.HandleFunc("/user/notifications/{userId}/{notificationId:[0-9]+}/read", userHandler.MarkNotificationRead).Methods("POST")
+	routes.HandleFunc("/user/notifications/{userId}/settings", userHandler.GetNotificationSettings).Methods("GET")
+	routes.HandleFunc("/user/notifications/{userId}/settings", userHandler.UpdateNotificationSettings).Methods("PUT")
+	routes.HandleFunc("/user/notifications/{userId}/settings", userHandler.ResetNotificationSettings).Methods("DELETE")
+	routes.HandleFunc("/user/notifications/{userId}/preferences", userHandler.GetNotificationPreferences).Methods("GET")
+	routes.HandleFunc("/user/notifications/{userId}/preferences", userHandler.UpdateNotificationPreferences).Methods("PUT")
+	routes.HandleFunc("/user/seen-notification/{userId}", userHandler.SeenNotification).Methods("POST")
+	routes.HandleFunc("/user/generate-pair", userHandler.GeneratePair).Methods("POST")
 }
@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	handlers "lingo-backend/controllers/handlers"
+	repository "lingo-backend/controllers/repository"
+	"lingo-backend/db/dbtest"
+	"lingo-backend/domain"
+	services "lingo-backend/service"
+	usecases "lingo-backend/usecase"
+
+	"github.com/gorilla/mux"
+)
+
+type discardEvents struct{}
+
+func (discardEvents) Publish(userId int64, event domain.Event) {}
+
+type discardNotifier struct{}
+
+func (discardNotifier) Notify(n domain.Notification) (domain.Notification, error) { return n, nil }
+
+// TestPairUserConcurrentRequests sends many /user/pair requests at once, each
+// user has to end up either in exactly one chat or alone on the waitlist.
+// It needs Postgres and is skipped without TEST_DATABASE_URL.
+func TestPairUserConcurrentRequests(t *testing.T) {
+	db := dbtest.Open(t)
+	db.SetMaxOpenConns(20)
+
+	var mu sync.Mutex
+	chats := map[string][]int64{}
+	userRepo := repository.NewUserRepo(db, nil, nil, discardEvents{}, discardNotifier{}).
+		WithChatCreator(func(ctx context.Context, chatId, name, lastMessage, systemMessage string, members []services.ChatMember) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, m := range members {
+				chats[chatId] = append(chats[chatId], m.ID)
+			}
+			return nil
+		})
+	userHandler := handlers.NewUserHandler(*usecases.NewUserUsecase(userRepo), nil, nil)
+	router := mux.NewRouter()
+	registerUserRoutes(router.PathPrefix("/api/v1").Subrouter(), userHandler)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	const users = 300
+	var wg sync.WaitGroup
+	errs := make(chan error, users)
+	for i := 1; i <= users; i++ {
+		wg.Add(1)
+		go func(userId int64) {
+			defer wg.Done()
+			body, _ := json.Marshal(map[string]interface{}{"userId": userId, "username": fmt.Sprintf("user%d", userId)})
+			resp, err := http.Post(server.URL+"/api/v1/user/pair", "application/json", bytes.NewReader(body))
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				errs <- fmt.Errorf("user %d: status %d", userId, resp.StatusCode)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("pair request failed: %v", err)
+	}
+
+	matched := map[int64]int{}
+	for chatId, members := range chats {
+		if len(members) != 2 || members[0] == members[1] {
+			t.Fatalf("chat %s has members %v", chatId, members)
+		}
+		for _, m := range members {
+			matched[m]++
+		}
+	}
+	for userId, n := range matched {
+		if n > 1 {
+			t.Errorf("user %d was matched %d times", userId, n)
+		}
+	}
+
+	rows, err := db.Query(`SELECT userid FROM waitlist`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var waiting []int64
+	for rows.Next() {
+		var userId int64
+		if err := rows.Scan(&userId); err != nil {
+			t.Fatal(err)
+		}
+		if matched[userId] > 0 {
+			t.Errorf("user %d is waiting although they were matched", userId)
+		}
+		waiting = append(waiting, userId)
+	}
+	if len(waiting) > 1 {
+		t.Errorf("%d users are waiting for each other: %v", len(waiting), waiting)
+	}
+	if len(matched)+len(waiting) != users {
+		t.Errorf("%d matched and %d waiting, want %d users in total", len(matched), len(waiting), users)
+	}
+
+	var logged int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM match_log`).Scan(&logged); err != nil {
+		t.Fatal(err)
+	}
+	if logged != len(chats) {
+		t.Errorf("%d matches logged, want %d", logged, len(chats))
+	}
+}
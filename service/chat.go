@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	db "firebase.google.com/go/v4/db"
+)
+
+type ChatMember struct {
+	ID         int64
+	Username   string
+	ProfileURL string
+}
+
+// ChatID is the id of a one-to-one chat, built from the sorted user IDs
+func ChatID(a, b int64) string {
+	if a < b {
+		return fmt.Sprintf("%d_%d", a, b)
+	}
+	return fmt.Sprintf("%d_%d", b, a)
+}
+
+// CreateChat writes the chat metadata to the Realtime DB, posts the opening
+// system message and links the chat to every member's chat list.
+func CreateChat(ctx context.Context, client *db.Client, chatID, name, lastMessage, systemMessage string, members []ChatMember) error {
+	var usernames []string
+	var ids []int64
+	var images []string
+	unreadCounts := map[string]int{}
+	for _, u := range members {
+		usernames = append(usernames, u.Username)
+		ids = append(ids, u.ID)
+		images = append(images, u.ProfileURL)
+		unreadCounts[fmt.Sprintf("%d", u.ID)] = 1
+	}
+
+	chatData := map[string]interface{}{
+		"name":                 name,
+		"isGroup":              len(members) > 2,
+		"participantUsernames": usernames,
+		"participantIds":       ids,
+		"participantImages":    images,
+		"lastMessage":          lastMessage,
+		"lastMessageTime":      time.Now().UnixMilli(),
+		"seenBy":               []string{},
+		"unreadCounts":         unreadCounts,
+	}
+
+	chatRef := client.NewRef("chats/" + chatID)
+	if err := chatRef.Set(ctx, chatData); err != nil {
+		return fmt.Errorf("failed to write chat: %w", err)
+	}
+
+	message := map[string]interface{}{
+		"text":            systemMessage,
+		"senderName":      "admin",
+		"senderId":        1,
+		"createdAt":       time.Now().UnixMilli(),
+		"seenBy":          []string{},
+		"isSystemMessage": true,
+		"isParticipating": []string{},
+	}
+
+	msgRef, err := client.NewRef("messages/"+chatID).Push(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create message reference: %w", err)
+	}
+	if err := msgRef.Set(ctx, message); err != nil {
+		return fmt.Errorf("failed to push system message: %w", err)
+	}
+
+	for _, u := range members {
+		userChatPath := fmt.Sprintf("userChats/%d/%s", u.ID, chatID)
+		if err := client.NewRef(userChatPath).Set(ctx, true); err != nil {
+			return fmt.Errorf("failed to link chat to user %d: %w", u.ID, err)
+		}
+	}
+	return nil
+}
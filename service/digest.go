@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"lingo-backend/domain"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	util "lingo-backend/utils"
+)
+
+//go:embed templates/*.tmpl
+var digestTemplateFiles embed.FS
+
+var digestTemplates = template.Must(template.New("digest").
+	Funcs(template.FuncMap{"join": strings.Join}).
+	ParseFS(digestTemplateFiles, "templates/*.tmpl"))
+
+// DigestData is what the digest templates render
+type DigestData struct {
+	Sessions     int
+	Missed       int
+	Streak       int
+	Partners     []string // usernames met in the period
+	NextPair     []string // usernames of the upcoming partners, if any
+	NextDeadline string
+}
+
+// DigestTime is the local time of day digests go out, configured with
+// DIGEST_TIME (HH:MM, default 19:00). Weekly digests go out on Sundays.
+func DigestTime() time.Time {
+	at, err := time.Parse("15:04", os.Getenv("DIGEST_TIME"))
+	if err != nil {
+		at, _ = time.Parse("15:04", "19:00")
+	}
+	return at
+}
+
+// SendDigests sends the daily or weekly digest to every user who opted in and
+// whose local digest time has passed. Each period is claimed in digest_runs
+// first so replicas and reruns never send it twice.
+func SendDigests(ctx context.Context, db *sql.DB, notifier domain.Notifier) error {
+	rows, err := db.QueryContext(ctx, `
+		SELECT userid, digest, timezone FROM notification_settings
+		WHERE digest IN ($1, $2)`, domain.DigestDaily, domain.DigestWeekly)
+	if err != nil {
+		return fmt.Errorf("failed to load digest subscribers: %w", err)
+	}
+	type subscriber struct {
+		userId   int64
+		period   string
+		timezone string
+	}
+	var subscribers []subscriber
+	for rows.Next() {
+		var s subscriber
+		if err := rows.Scan(&s.userId, &s.period, &s.timezone); err != nil {
+			rows.Close()
+			return err
+		}
+		subscribers = append(subscribers, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, s := range subscribers {
+		start, due := digestPeriod(s.period, now, s.timezone)
+		if !due {
+			continue
+		}
+		if err := sendDigest(ctx, db, notifier, s.userId, s.period, start); err != nil {
+			log.Printf("failed to send %s digest to %d: %v\n", s.period, s.userId, err)
+		}
+	}
+	return nil
+}
+
+// digestPeriod returns the first local date the digest covers and whether it
+// is time to send it
+func digestPeriod(period string, now time.Time, timezone string) (time.Time, bool) {
+	loc, err := time.LoadLocation(util.ResolveTimezone(timezone))
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	at := DigestTime()
+	sendAt := time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+
+	if period == domain.DigestWeekly {
+		if local.Weekday() != time.Sunday {
+			return time.Time{}, false
+		}
+		return today.AddDate(0, 0, -6), !local.Before(sendAt) // Monday
+	}
+	return today, !local.Before(sendAt)
+}
+
+// sendDigest claims the period in digest_runs so only one replica sends it,
+// and gives the claim back when the digest can't be delivered so the next run
+// retries it
+func sendDigest(ctx context.Context, db *sql.DB, notifier domain.Notifier, userId int64, period string, start time.Time) (err error) {
+	periodStart := start.Format("2006-01-02")
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO digest_runs (userid, period, period_start)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`, userId, period, periodStart)
+	if err != nil {
+		return fmt.Errorf("failed to claim digest: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil // already sent
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		_, releaseErr := db.Exec(`
+			DELETE FROM digest_runs WHERE userid = $1 AND period = $2 AND period_start = $3`,
+			userId, period, periodStart)
+		if releaseErr != nil {
+			log.Printf("failed to release %s digest claim of %d: %v\n", period, userId, releaseErr)
+		}
+	}()
+
+	data, err := BuildDigest(ctx, db, userId, start)
+	if err != nil {
+		return err
+	}
+	message, err := RenderDigest(period, data)
+	if err != nil {
+		return err
+	}
+	_, err = notifier.Notify(domain.Notification{
+		RecipientID: userId,
+		Type:        domain.NotificationDigest,
+		Message:     message,
+		Payload: map[string]interface{}{
+			"period":      period,
+			"periodStart": periodStart,
+			"sessions":    data.Sessions,
+			"missed":      data.Missed,
+			"streak":      data.Streak,
+			"partners":    data.Partners,
+		},
+	})
+	return err
+}
+
+// BuildDigest collects the user's attendance, streak and partners since the
+// given local date, and their upcoming pair if it is still open. Times are
+// shown in the location of since.
+func BuildDigest(ctx context.Context, db *sql.DB, userId int64, since time.Time) (DigestData, error) {
+	var data DigestData
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE attended), COUNT(*) FILTER (WHERE NOT attended)
+		FROM attendance
+		WHERE userid = $1 AND date >= $2`, userId, since.Format("2006-01-02")).Scan(&data.Sessions, &data.Missed)
+	if err != nil {
+		return data, fmt.Errorf("failed to count sessions: %w", err)
+	}
+
+	if data.Streak, err = CurrentStreak(ctx, db, userId); err != nil {
+		return data, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT '@' || h.partner_username
+		FROM pair_history h
+		JOIN attendance a ON a.userid = h.userid AND a.pair_id = h.pair_id AND a.attended
+		WHERE h.userid = $1 AND h.date >= $2 AND a.date >= $2 AND h.partner_username <> ''`,
+		userId, since.Format("2006-01-02"))
+	if err != nil {
+		return data, fmt.Errorf("failed to load partners: %w", err)
+	}
+	for rows.Next() {
+		var partner string
+		if err := rows.Scan(&partner); err != nil {
+			rows.Close()
+			return data, err
+		}
+		data.Partners = append(data.Partners, partner)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return data, err
+	}
+
+	var user1, user2 int64
+	var user3 sql.NullInt64
+	var username1, username2, username3 sql.NullString
+	var deadline sql.NullTime
+	err = db.QueryRowContext(ctx, `
+		SELECT user1id, user2id, user3id, username1, username2, username3, deadline
+		FROM pairs
+		WHERE $1 IN (user1id, user2id, user3id) AND (deadline IS NULL OR deadline > NOW())
+		ORDER BY created_at DESC
+		LIMIT 1`, userId).Scan(&user1, &user2, &user3, &username1, &username2, &username3, &deadline)
+	if err == sql.ErrNoRows {
+		return data, nil
+	}
+	if err != nil {
+		return data, fmt.Errorf("failed to load next pair: %w", err)
+	}
+	members := []struct {
+		id       int64
+		username string
+	}{{user1, username1.String}, {user2, username2.String}, {user3.Int64, username3.String}}
+	for _, m := range members {
+		if m.id != 0 && m.id != userId {
+			data.NextPair = append(data.NextPair, util.Mention(m.username))
+		}
+	}
+	if deadline.Valid {
+		data.NextDeadline = deadline.Time.In(since.Location()).Format("15:04 MST")
+	}
+	return data, nil
+}
+
+// RenderDigest renders the template for the period (daily or weekly)
+func RenderDigest(period string, data DigestData) (string, error) {
+	var buf bytes.Buffer
+	if err := digestTemplates.ExecuteTemplate(&buf, "digest_"+period+".tmpl", data); err != nil {
+		return "", fmt.Errorf("failed to render %s digest: %w", period, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
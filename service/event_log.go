@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"lingo-backend/domain"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// eventsChannel is the Postgres NOTIFY channel every instance listens on
+const eventsChannel = "lingo_events"
+
+// EventLog persists every event in user_events and announces it with NOTIFY.
+// Delivery happens in ListenForEvents on every instance, so a user gets the
+// event whichever replica their connection is attached to.
+type EventLog struct {
+	db  *sql.DB
+	hub *Hub
+}
+
+func NewEventLog(db *sql.DB, hub *Hub) *EventLog {
+	return &EventLog{db: db, hub: hub}
+}
+
+type eventNotification struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"userId"`
+}
+
+func (l *EventLog) Publish(userId int64, event domain.Event) {
+	payload, err := json.Marshal(event.Payload)
+	if err != nil {
+		log.Printf("failed to encode %s event for user %d: %v\n", event.Type, userId, err)
+		return
+	}
+
+	// Only the id travels through NOTIFY, payloads can exceed its 8000 byte limit
+	_, err = l.db.Exec(`
+		WITH e AS (
+			INSERT INTO user_events (userid, type, payload) VALUES ($1, $2, $3)
+			RETURNING id, userid
+		)
+		SELECT pg_notify($4, json_build_object('id', id, 'userId', userid)::text) FROM e`,
+		userId, event.Type, payload, eventsChannel)
+	if err != nil {
+		// Still worth delivering to this instance's clients, it just can't be replayed
+		log.Printf("failed to store %s event for user %d: %v\n", event.Type, userId, err)
+		l.hub.Publish(userId, event)
+	}
+}
+
+// ListenForEvents delivers events published by any instance to the clients
+// connected to this one. It blocks until ctx is done.
+func ListenForEvents(ctx context.Context, connString string, db *sql.DB, hub *Hub) error {
+	listener := pq.NewListener(connString, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("event listener:", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(eventsChannel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", eventsChannel, err)
+	}
+	log.Println("Listening for events on", eventsChannel)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				// Reconnected, anything missed meanwhile is still in the log for SSE resumes
+				continue
+			}
+			var note eventNotification
+			if err := json.Unmarshal([]byte(n.Extra), &note); err != nil {
+				log.Println("bad event notification:", err)
+				continue
+			}
+			if !hub.HasSubscribers(note.UserID) {
+				continue
+			}
+			event, err := loadEvent(ctx, db, note.ID)
+			if err != nil {
+				log.Printf("failed to load event %d: %v\n", note.ID, err)
+				continue
+			}
+			hub.Publish(event.UserID, event)
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
+
+func loadEvent(ctx context.Context, db *sql.DB, id int64) (domain.Event, error) {
+	var event domain.Event
+	var payload []byte
+	err := db.QueryRowContext(ctx, `SELECT id, userid, type, payload, created_at FROM user_events WHERE id = $1`, id).
+		Scan(&event.ID, &event.UserID, &event.Type, &payload, &event.CreatedAt)
+	event.Payload = json.RawMessage(payload)
+	return event, err
+}
+
+// PruneEventLog drops events too old to be worth replaying
+func PruneEventLog(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM user_events WHERE created_at < NOW() - INTERVAL '7 days'`)
+	if err != nil {
+		return fmt.Errorf("failed to prune user_events: %w", err)
+	}
+	return nil
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"lingo-backend/domain"
 	"log"
 	"math/rand"
 	"sort"
+	"strings"
 	"time"
 
+	util "lingo-backend/utils"
+
 	firebase "firebase.google.com/go/v4"
 	db "firebase.google.com/go/v4/db"
 	"google.golang.org/api/iterator"
@@ -19,12 +23,14 @@ type candidate struct {
 	ID         int64
 	Username   string
 	ProfileURL string
+	Language   string // first language the user practices, empty if not set up
+	Level      string
 }
 type trio struct {
 	u1, u2, u3 *candidate // u3 can be nil
 }
 
-func GenerateDailyPairs(db *sql.DB, rtdbClient *db.Client) (string, error) {
+func GenerateDailyPairs(db *sql.DB, rtdbClient *db.Client, events domain.EventPublisher, notifier domain.Notifier) (string, error) {
 	ctx := context.Background()
 	opt := option.WithCredentialsFile("lingo-firestore.json")
 	app, err := firebase.NewApp(ctx, nil, opt)
@@ -40,6 +46,7 @@ func GenerateDailyPairs(db *sql.DB, rtdbClient *db.Client) (string, error) {
 
 	rand.Seed(time.Now().UnixNano())
 	rand.Shuffle(len(users), func(i, j int) { users[i], users[j] = users[j], users[i] })
+	groupByLanguage(users)
 
 	var groups []trio
 	for i := 0; i < len(users); i += 2 {
@@ -59,6 +66,10 @@ func GenerateDailyPairs(db *sql.DB, rtdbClient *db.Client) (string, error) {
 		})
 	}
 
+	deadline := DefaultParticipationDeadline(time.Now())
+	loc, _ := time.LoadLocation(util.DefaultTimezone())
+	deadlines := map[string]time.Time{}
+
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return "", err
@@ -75,15 +86,19 @@ func GenerateDailyPairs(db *sql.DB, rtdbClient *db.Client) (string, error) {
 		// }
 		pairID := generatePairID(&g)
 
-		_, err := tx.ExecContext(ctx, `
+		var pairDeadline time.Time
+		err := tx.QueryRowContext(ctx, `
 			INSERT INTO pairs (id, user1id, user2id, user3id,
-				username1, username2, username3, date, status)
-			VALUES ($1,$2,$3,$4,$5,$6,$7,CURRENT_DATE,'pending')`,
+				username1, username2, username3, date, status, deadline)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,CURRENT_DATE,'pending',
+				COALESCE((SELECT deadline FROM participation_deadlines WHERE date = CURRENT_DATE), $8))
+			RETURNING deadline`,
 			pairID, g.u1.ID, g.u2.ID, idOrZero(g.u3),
-			g.u1.Username, g.u2.Username, usernameOrEmpty(g.u3))
+			g.u1.Username, g.u2.Username, usernameOrEmpty(g.u3), deadline).Scan(&pairDeadline)
 		if err != nil {
 			return "", fmt.Errorf("insert pair failed: %w", err)
 		}
+		deadlines[pairID] = pairDeadline
 
 		// Participation
 		for _, u := range []*candidate{g.u1, g.u2, g.u3} {
@@ -94,6 +109,21 @@ func GenerateDailyPairs(db *sql.DB, rtdbClient *db.Client) (string, error) {
 				}
 			}
 		}
+
+		// History
+		for _, u := range []*candidate{g.u1, g.u2, g.u3} {
+			for _, partner := range []*candidate{g.u1, g.u2, g.u3} {
+				if u == nil || partner == nil || u.ID == partner.ID {
+					continue
+				}
+				if _, err := tx.ExecContext(ctx, `
+					INSERT INTO pair_history (date, pair_id, userid, partner_id, partner_username)
+					VALUES (CURRENT_DATE, $1, $2, $3, $4)
+					ON CONFLICT DO NOTHING`, pairID, u.ID, partner.ID, partner.Username); err != nil {
+					return "", fmt.Errorf("insert pair history: %w", err)
+				}
+			}
+		}
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -112,10 +142,63 @@ func GenerateDailyPairs(db *sql.DB, rtdbClient *db.Client) (string, error) {
 			return "", fmt.Errorf("failed to push pair %s to Realtime DB: %w", pairID, err)
 		}
 		log.Printf("✅ Pair %s pushed to Realtime DB\n", pairID)
+
+		members := []map[string]interface{}{}
+		for _, u := range []*candidate{g.u1, g.u2, g.u3} {
+			if u != nil {
+				members = append(members, map[string]interface{}{"id": u.ID, "username": u.Username, "profileUrl": u.ProfileURL})
+			}
+		}
+		for _, u := range []*candidate{g.u1, g.u2, g.u3} {
+			if u != nil {
+				events.Publish(u.ID, domain.Event{Type: domain.EventPairCreated, Payload: map[string]interface{}{
+					"pairId":   pairID,
+					"members":  members,
+					"deadline": deadlines[pairID],
+				}})
+				notifyPairAssigned(notifier, u, &g, pairID, deadlines[pairID].In(loc))
+			}
+		}
 	}
 	return fmt.Sprintf("✅ %d group(s) created for %s", len(groups), time.Now().Format("2006-01-02")), nil
 }
 
+// groupByLanguage puts users practicing the same language, then at the same
+// level, next to each other so neighbours get paired. The order within a
+// group stays shuffled, only the odd one out of a group crosses over.
+func groupByLanguage(users []candidate) {
+	sort.SliceStable(users, func(i, j int) bool {
+		li, lj := strings.ToLower(users[i].Language), strings.ToLower(users[j].Language)
+		if li != lj {
+			return li < lj
+		}
+		return strings.ToLower(users[i].Level) < strings.ToLower(users[j].Level)
+	})
+}
+
+// notifyPairAssigned tells a member who they're paired with today
+func notifyPairAssigned(notifier domain.Notifier, member *candidate, g *trio, pairID string, deadline time.Time) {
+	var partners []string
+	for _, u := range []*candidate{g.u1, g.u2, g.u3} {
+		if u != nil && u.ID != member.ID {
+			partners = append(partners, util.Mention(u.Username))
+		}
+	}
+	if len(partners) == 0 {
+		return
+	}
+	_, err := notifier.Notify(domain.Notification{
+		RecipientID: member.ID,
+		Type:        domain.NotificationPairAssigned,
+		Message: fmt.Sprintf("📅 You've been paired with %s for today's conversation! Confirm before %s.",
+			strings.Join(partners, " and "), deadline.Format("15:04 MST")),
+		Payload: map[string]interface{}{"pairId": pairID, "partners": partners, "deadline": deadline},
+	})
+	if err != nil {
+		log.Printf("failed to notify %d about pair %s: %v\n", member.ID, pairID, err)
+	}
+}
+
 func generatePairID(t *trio) string {
 	cleanAndSort(t)
 	id := fmt.Sprintf("%d_%d", t.u1.ID, t.u2.ID)
@@ -161,9 +244,13 @@ func usernameOrEmpty(c *candidate) string {
 }
 
 type FirebaseUser struct {
-	UserID     int64  `firestore:"userId"`
-	Username   string `firestore:"username"`
-	ProfileURL string `firestore:"profileUrl"`
+	UserID     int64    `firestore:"userId"`
+	Username   string   `firestore:"username"`
+	ProfileURL string   `firestore:"profileUrl"`
+	Paused     bool     `firestore:"paused"`
+	Inactive   bool     `firestore:"inactive"` // blocked the bot or deleted their account
+	Languages  []string `firestore:"languages"`
+	Level      string   `firestore:"level"`
 }
 
 func fetchAllUsers(app *firebase.App) ([]candidate, error) {
@@ -186,12 +273,16 @@ func fetchAllUsers(app *firebase.App) ([]candidate, error) {
 			return nil, err
 		}
 		var u FirebaseUser
-		if err := doc.DataTo(&u); err != nil {
+		if err := doc.DataTo(&u); err != nil || u.Paused || u.Inactive {
 			continue
 		}
-		users = append(users, candidate{
-			ID: u.UserID, Username: u.Username, ProfileURL: u.ProfileURL,
-		})
+		c := candidate{
+			ID: u.UserID, Username: u.Username, ProfileURL: u.ProfileURL, Level: u.Level,
+		}
+		if len(u.Languages) > 0 {
+			c.Language = u.Languages[0]
+		}
+		users = append(users, c)
 	}
 	return users, nil
 }
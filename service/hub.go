@@ -0,0 +1,94 @@
+package services
+
+import (
+	"lingo-backend/domain"
+	"log"
+	"sync"
+	"time"
+)
+
+// subscriptionBuffer is how many events a slow client can fall behind before it's dropped
+const subscriptionBuffer = 32
+
+// Hub fans events out to every connection a user has open, one user can
+// be connected from several tabs or devices at once.
+type Hub struct {
+	mu            sync.RWMutex
+	subscriptions map[int64]map[*Subscription]struct{}
+}
+
+type Subscription struct {
+	UserID int64
+	Events <-chan domain.Event
+
+	events chan domain.Event
+	hub    *Hub
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: map[int64]map[*Subscription]struct{}{},
+	}
+}
+
+func (h *Hub) Subscribe(userId int64) *Subscription {
+	events := make(chan domain.Event, subscriptionBuffer)
+	sub := &Subscription{UserID: userId, Events: events, events: events, hub: h}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscriptions[userId] == nil {
+		h.subscriptions[userId] = map[*Subscription]struct{}{}
+	}
+	h.subscriptions[userId][sub] = struct{}{}
+	return sub
+}
+
+// Close stops the subscription, its Events channel is closed once removed
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
+
+// remove must be called with the lock held
+func (h *Hub) remove(sub *Subscription) {
+	subs, ok := h.subscriptions[sub.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	close(sub.events)
+	if len(subs) == 0 {
+		delete(h.subscriptions, sub.UserID)
+	}
+}
+
+func (h *Hub) HasSubscribers(userId int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscriptions[userId]) > 0
+}
+
+// Publish delivers the event to every connection of the user. Connections
+// that can't keep up are dropped rather than blocking everyone else.
+func (h *Hub) Publish(userId int64, event domain.Event) {
+	event.UserID = userId
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscriptions[userId] {
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("dropping slow subscriber of user %d\n", userId)
+			h.remove(sub)
+		}
+	}
+}
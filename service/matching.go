@@ -0,0 +1,87 @@
+package services
+
+import (
+	"lingo-backend/domain"
+	"os"
+	"strings"
+	"time"
+)
+
+// Weights of each preference in a compatibility score out of 100
+const (
+	languageWeight      = 40
+	levelWeight         = 25
+	sessionLengthWeight = 20
+	topicWeight         = 15
+)
+
+// WaitingRequest is an on-demand pair request sitting on the waitlist
+type WaitingRequest struct {
+	UserID      int64
+	Preferences domain.MatchPreferences
+	Waited      time.Duration
+}
+
+// PreferenceRelaxAfter is how long it takes for the required compatibility to
+// drop to zero, configured with PREFERENCE_RELAX_AFTER. Past that anyone matches.
+func PreferenceRelaxAfter() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("PREFERENCE_RELAX_AFTER"))
+	if err != nil || d <= 0 {
+		return 5 * time.Minute
+	}
+	return d
+}
+
+// PreferenceScore rates how well two requests fit together, from 0 to 100.
+// A preference left empty on either side is compatible with anything.
+func PreferenceScore(a, b domain.MatchPreferences) int {
+	score := 0
+	if a.Language == "" || b.Language == "" || strings.EqualFold(a.Language, b.Language) {
+		score += languageWeight
+	}
+	if a.Level == "" || b.Level == "" || strings.EqualFold(a.Level, b.Level) {
+		score += levelWeight
+	}
+	switch diff := a.SessionLength - b.SessionLength; {
+	case a.SessionLength == 0 || b.SessionLength == 0 || (diff >= -15 && diff <= 15):
+		score += sessionLengthWeight
+	case diff >= -30 && diff <= 30:
+		score += sessionLengthWeight / 2
+	}
+	if a.Topic == "" || b.Topic == "" || strings.EqualFold(a.Topic, b.Topic) {
+		score += topicWeight
+	}
+	return score
+}
+
+// RequiredScore is the compatibility two requests need to be matched. It
+// starts at a perfect fit and widens linearly the longer someone has waited,
+// so nobody waits forever for an ideal partner.
+func RequiredScore(waited time.Duration) int {
+	relax := PreferenceRelaxAfter()
+	if waited >= relax {
+		return 0
+	}
+	return int(100 * (relax - waited) / relax)
+}
+
+// BestMatch returns the index of the most compatible waiting request that clears
+// the bar for how long the pair has waited, or -1 if there is none. Ties go to
+// whoever has waited the longest.
+func BestMatch(prefs domain.MatchPreferences, waited time.Duration, waiting []WaitingRequest) int {
+	best, bestScore := -1, -1
+	for i, w := range waiting {
+		longest := waited
+		if w.Waited > longest {
+			longest = w.Waited
+		}
+		score := PreferenceScore(prefs, w.Preferences)
+		if score < RequiredScore(longest) {
+			continue
+		}
+		if score > bestScore || (score == bestScore && w.Waited > waiting[best].Waited) {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
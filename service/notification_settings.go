@@ -0,0 +1,162 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"lingo-backend/domain"
+	"slices"
+	"time"
+
+	util "lingo-backend/utils"
+)
+
+// DefaultNotificationSettings applies to users who never changed anything:
+// every channel on, no quiet hours, no digest, default Telegram mirroring.
+func DefaultNotificationSettings() domain.NotificationSettings {
+	return domain.NotificationSettings{
+		InApp:     true,
+		WebSocket: true,
+		Telegram:  true,
+		Timezone:  util.DefaultTimezone(),
+		Digest:    domain.DigestOff,
+		Types:     defaultNotificationPreferences(),
+	}
+}
+
+func defaultNotificationPreferences() []domain.NotificationPreference {
+	prefs := make([]domain.NotificationPreference, 0, len(domain.NotificationTypes))
+	for _, notificationType := range domain.NotificationTypes {
+		prefs = append(prefs, domain.NotificationPreference{
+			Type:     notificationType,
+			Enabled:  true,
+			Telegram: TelegramMirrored[notificationType],
+		})
+	}
+	return prefs
+}
+
+// LoadNotificationSettings reads the user's settings, filling in defaults
+// for anything they never set
+func LoadNotificationSettings(db *sql.DB, userId int64) (domain.NotificationSettings, error) {
+	settings := DefaultNotificationSettings()
+
+	var quietStart, quietEnd sql.NullString
+	err := db.QueryRow(`
+		SELECT in_app, websocket, telegram, quiet_start, quiet_end, timezone, digest
+		FROM notification_settings
+		WHERE userid = $1`, userId).Scan(
+		&settings.InApp, &settings.WebSocket, &settings.Telegram,
+		&quietStart, &quietEnd, &settings.Timezone, &settings.Digest)
+	if err != nil && err != sql.ErrNoRows {
+		return settings, fmt.Errorf("failed to load notification settings: %w", err)
+	}
+	if quietStart.Valid && quietEnd.Valid {
+		settings.QuietHours = &domain.QuietHours{Start: quietStart.String, End: quietEnd.String}
+	}
+	settings.Timezone = util.ResolveTimezone(settings.Timezone)
+
+	settings.Types, err = LoadNotificationPreferences(db, userId)
+	return settings, err
+}
+
+// LoadNotificationPreferences returns the preference of every notification
+// type, types the user never changed get their default
+func LoadNotificationPreferences(db *sql.DB, userId int64) ([]domain.NotificationPreference, error) {
+	rows, err := db.Query(`SELECT type, enabled, telegram FROM notification_preferences WHERE userid = $1`, userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notification preferences: %w", err)
+	}
+	defer rows.Close()
+
+	saved := map[string]domain.NotificationPreference{}
+	for rows.Next() {
+		var pref domain.NotificationPreference
+		if err := rows.Scan(&pref.Type, &pref.Enabled, &pref.Telegram); err != nil {
+			return nil, err
+		}
+		saved[pref.Type] = pref
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	prefs := defaultNotificationPreferences()
+	for i, pref := range prefs {
+		if s, ok := saved[pref.Type]; ok {
+			prefs[i] = s
+		}
+	}
+	return prefs, nil
+}
+
+// ValidateNotificationSettings checks values coming from clients
+func ValidateNotificationSettings(settings domain.NotificationSettings) error {
+	if !slices.Contains([]string{domain.DigestOff, domain.DigestDaily, domain.DigestWeekly}, settings.Digest) {
+		return fmt.Errorf("%w: digest must be off, daily or weekly", domain.ErrInvalidNotificationSettings)
+	}
+	if settings.Timezone != "" {
+		if _, err := time.LoadLocation(settings.Timezone); err != nil {
+			return fmt.Errorf("%w: unknown timezone %q", domain.ErrInvalidNotificationSettings, settings.Timezone)
+		}
+	}
+	if q := settings.QuietHours; q != nil {
+		if _, err := time.Parse("15:04", q.Start); err != nil {
+			return fmt.Errorf("%w: quiet hours start must be HH:MM", domain.ErrInvalidNotificationSettings)
+		}
+		if _, err := time.Parse("15:04", q.End); err != nil {
+			return fmt.Errorf("%w: quiet hours end must be HH:MM", domain.ErrInvalidNotificationSettings)
+		}
+	}
+	for _, pref := range settings.Types {
+		if !slices.Contains(domain.NotificationTypes, pref.Type) {
+			return fmt.Errorf("%w: %q", domain.ErrUnknownNotificationType, pref.Type)
+		}
+	}
+	return nil
+}
+
+// QuietHoursEnd returns when the user's current quiet hours end, or false
+// when now is outside of them
+func QuietHoursEnd(settings domain.NotificationSettings, now time.Time) (time.Time, bool) {
+	if settings.QuietHours == nil {
+		return time.Time{}, false
+	}
+	start, err1 := time.Parse("15:04", settings.QuietHours.Start)
+	end, err2 := time.Parse("15:04", settings.QuietHours.End)
+	if err1 != nil || err2 != nil || start.Equal(end) {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(util.ResolveTimezone(settings.Timezone))
+	if err != nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	from := start.Hour()*60 + start.Minute()
+	to := end.Hour()*60 + end.Minute()
+	var quiet bool
+	if from < to {
+		quiet = minute >= from && minute < to
+	} else {
+		// wraps past midnight, e.g. 22:00-07:00
+		quiet = minute >= from || minute < to
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+	until := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+	if minute >= to {
+		until = until.AddDate(0, 0, 1)
+	}
+	return until, true
+}
+
+// preferenceFor returns the user's preference for a notification type
+func preferenceFor(settings domain.NotificationSettings, notificationType string) domain.NotificationPreference {
+	for _, pref := range settings.Types {
+		if pref.Type == notificationType {
+			return pref
+		}
+	}
+	return domain.NotificationPreference{Type: notificationType, Enabled: true, Telegram: TelegramMirrored[notificationType]}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"lingo-backend/domain"
+	"log"
+	"time"
+)
+
+// TelegramSender delivers messages to a Telegram chat. For private chats the
+// chat ID is the user's Telegram ID.
+type TelegramSender interface {
+	Send(chatID int64, text string) error
+	// SendNotification lets the sender choose how a notification looks in the
+	// chat. It is held back until notBefore when that is set.
+	SendNotification(notification domain.Notification, notBefore time.Time) error
+}
+
+// TelegramMirrored lists the notification types that are also sent to the
+// recipient's Telegram chat unless they turned it off.
+var TelegramMirrored = map[string]bool{
+	domain.NotificationPairAssigned:     true,
+	domain.NotificationPartnerConfirmed: true,
+	domain.NotificationReminder:         true,
+	domain.NotificationStreak:           true,
+	domain.NotificationDigest:           true,
+}
+
+// Notifier is the single place notifications are created, every producer
+// goes through it so the notification is stored and pushed the same way.
+type Notifier struct {
+	db       *sql.DB
+	events   domain.EventPublisher
+	telegram TelegramSender // nil disables Telegram delivery
+}
+
+func NewNotifier(db *sql.DB, events domain.EventPublisher, telegram TelegramSender) *Notifier {
+	return &Notifier{db: db, events: events, telegram: telegram}
+}
+
+// Notify delivers the notification on the channels the recipient has
+// enabled. A disabled type is dropped and returned without an ID.
+func (n *Notifier) Notify(notification domain.Notification) (domain.Notification, error) {
+	settings, err := LoadNotificationSettings(n.db, notification.RecipientID)
+	if err != nil {
+		log.Printf("failed to load notification settings of %d, using defaults: %v\n", notification.RecipientID, err)
+		settings = DefaultNotificationSettings()
+	}
+	pref := preferenceFor(settings, notification.Type)
+	if !pref.Enabled {
+		return notification, nil
+	}
+
+	if notification.Payload == nil {
+		notification.Payload = map[string]interface{}{}
+	}
+	if settings.InApp {
+		payload, err := json.Marshal(notification.Payload)
+		if err != nil {
+			return notification, err
+		}
+		err = n.db.QueryRow(`
+		INSERT INTO notifications (recipient_id, type, message, payload)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+			notification.RecipientID, notification.Type, notification.Message, payload).Scan(&notification.ID, &notification.CreatedAt)
+		if err != nil {
+			return notification, fmt.Errorf("failed to insert notification: %w", err)
+		}
+	}
+
+	if settings.WebSocket {
+		n.events.Publish(notification.RecipientID, domain.Event{Type: domain.EventNotificationCreated, Payload: notification})
+	}
+	if settings.Telegram && pref.Telegram {
+		// during quiet hours it waits in the outbox until they end
+		notBefore, _ := QuietHoursEnd(settings, time.Now())
+		n.sendToTelegram(notification, notBefore)
+	}
+	return notification, nil
+}
+
+// sendToTelegram mirrors the notification to the recipient's chat. Failures
+// are only logged, the other channels already have it.
+func (n *Notifier) sendToTelegram(notification domain.Notification, notBefore time.Time) {
+	if n.telegram == nil {
+		return
+	}
+	if err := n.telegram.SendNotification(notification, notBefore); err != nil {
+		log.Printf("failed to send %s notification to %d on Telegram: %v\n", notification.Type, notification.RecipientID, err)
+	}
+}
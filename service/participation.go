@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"lingo-backend/domain"
+	util "lingo-backend/utils"
+)
+
+// DefaultParticipationDeadline is the time members have to confirm a pair
+// created at now. It is PARTICIPATION_DEADLINE (HH:MM, default 21:00) in the
+// default timezone, pushed to the next day if that leaves less than an hour.
+func DefaultParticipationDeadline(now time.Time) time.Time {
+	clock := os.Getenv("PARTICIPATION_DEADLINE")
+	at, err := time.Parse("15:04", clock)
+	if err != nil {
+		at, _ = time.Parse("15:04", "21:00")
+	}
+
+	loc, _ := time.LoadLocation(util.DefaultTimezone())
+	local := now.In(loc)
+	deadline := time.Date(local.Year(), local.Month(), local.Day(), at.Hour(), at.Minute(), 0, 0, loc)
+	if deadline.Before(now.Add(time.Hour)) {
+		deadline = deadline.AddDate(0, 0, 1)
+	}
+	return deadline
+}
+
+// ParticipationReminderBefore is how long before the deadline members who haven't
+// answered get a bot reminder, configured with PARTICIPATION_REMINDER_BEFORE.
+func ParticipationReminderBefore() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("PARTICIPATION_REMINDER_BEFORE"))
+	if err != nil || d <= 0 {
+		return time.Hour
+	}
+	return d
+}
+
+// MarkNoShows closes participation for pairs past their deadline, anyone who
+// never answered is recorded as not participating.
+func MarkNoShows(ctx context.Context, db *sql.DB) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE pair_participation pp
+		SET is_participating = false, no_show = true, responded_at = NOW()
+		FROM pairs p
+		WHERE pp.pair_id = p.id
+		  AND pp.is_participating IS NULL
+		  AND p.deadline IS NOT NULL AND p.deadline <= NOW()`)
+	if err != nil {
+		return fmt.Errorf("failed to mark no-shows: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("⌛ %d member(s) marked as no-show\n", n)
+	}
+	return nil
+}
+
+// SendDeadlineReminders nudges members who haven't answered shortly before the
+// deadline. The reminders are claimed by setting reminded_at in a short
+// transaction so replicas skip them, and sent after it commits. A reminder
+// that fails to go out is released again to be retried on the next run.
+func SendDeadlineReminders(ctx context.Context, db *sql.DB, notifier domain.Notifier) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT pp.userid, pp.pair_id, p.deadline
+		FROM pair_participation pp
+		JOIN pairs p ON p.id = pp.pair_id
+		WHERE pp.is_participating IS NULL
+		  AND pp.reminded_at IS NULL
+		  AND p.deadline > NOW()
+		  AND p.deadline <= NOW() + make_interval(secs => $1)
+		FOR UPDATE OF pp SKIP LOCKED`, ParticipationReminderBefore().Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to claim reminders: %w", err)
+	}
+
+	type due struct {
+		userId   int64
+		pairId   string
+		deadline time.Time
+	}
+	var reminders []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.userId, &d.pairId, &d.deadline); err != nil {
+			rows.Close()
+			return err
+		}
+		reminders = append(reminders, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, d := range reminders {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE pair_participation SET reminded_at = NOW()
+			WHERE pair_id = $1 AND userid = $2`, d.pairId, d.userId); err != nil {
+			return fmt.Errorf("failed to mark reminder as sent: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	loc, _ := time.LoadLocation(util.DefaultTimezone())
+	for _, d := range reminders {
+		_, err := notifier.Notify(domain.Notification{
+			RecipientID: d.userId,
+			Type:        domain.NotificationReminder,
+			Message: fmt.Sprintf("⏰ Are you joining today's conversation? Please confirm before %s or you'll be marked as a no-show.",
+				d.deadline.In(loc).Format("15:04 MST")),
+			Payload: map[string]interface{}{"pairId": d.pairId, "deadline": d.deadline},
+		})
+		if err == nil {
+			continue
+		}
+		log.Printf("failed to send deadline reminder to %d: %v\n", d.userId, err)
+		if _, err := db.ExecContext(ctx, `
+			UPDATE pair_participation SET reminded_at = NULL
+			WHERE pair_id = $1 AND userid = $2`, d.pairId, d.userId); err != nil {
+			log.Printf("failed to release deadline reminder of %d: %v\n", d.userId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Job is a piece of background work that runs on a fixed interval.
+// Jobs must be safe to run on several replicas at once.
+type Job struct {
+	Name  string
+	Every time.Duration
+	Run   func(ctx context.Context) error
+}
+
+// RunJobs starts every job on its own ticker and blocks until ctx is done.
+func RunJobs(ctx context.Context, jobs ...Job) {
+	for _, job := range jobs {
+		go func(job Job) {
+			ticker := time.NewTicker(job.Every)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := job.Run(ctx); err != nil {
+						log.Printf("job %s failed: %v\n", job.Name, err)
+					}
+				}
+			}
+		}(job)
+	}
+	<-ctx.Done()
+}
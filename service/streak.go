@@ -0,0 +1,108 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"lingo-backend/domain"
+	"log"
+	"os"
+	"time"
+)
+
+// minStreakAtRisk is the shortest streak worth a warning
+const minStreakAtRisk = 2
+
+// StreakWarningBefore is how long before the deadline members with a running
+// streak who haven't answered are warned, configured with STREAK_WARNING_BEFORE.
+func StreakWarningBefore() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("STREAK_WARNING_BEFORE"))
+	if err != nil || d <= 0 {
+		return 3 * time.Hour
+	}
+	return d
+}
+
+// CurrentStreak counts the user's consecutive attended pair dates in the
+// attendance ledger, ending at the most recent one. Days without a pair
+// don't break the streak, a missed one does.
+func CurrentStreak(ctx context.Context, db *sql.DB, userId int64) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT attended FROM attendance
+		WHERE userid = $1
+		ORDER BY date DESC`, userId)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load attendance: %w", err)
+	}
+	defer rows.Close()
+
+	streak := 0
+	for rows.Next() {
+		var attended bool
+		if err := rows.Scan(&attended); err != nil {
+			return 0, err
+		}
+		if !attended {
+			break
+		}
+		streak++
+	}
+	return streak, rows.Err()
+}
+
+// WarnStreaksAtRisk warns members who haven't answered today's pair that
+// they are about to lose their streak. Rows are claimed with streak_warned_at
+// so each member is checked once per pair.
+func WarnStreaksAtRisk(ctx context.Context, db *sql.DB, notifier domain.Notifier) error {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE pair_participation pp
+		SET streak_warned_at = NOW()
+		FROM pairs p
+		WHERE pp.pair_id = p.id
+		  AND pp.is_participating IS NULL
+		  AND pp.streak_warned_at IS NULL
+		  AND p.deadline > NOW()
+		  AND p.deadline <= NOW() + make_interval(secs => $1)
+		RETURNING pp.userid, pp.pair_id`, StreakWarningBefore().Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to claim streak warnings: %w", err)
+	}
+	type claimed struct {
+		userId int64
+		pairId string
+	}
+	var members []claimed
+	for rows.Next() {
+		var c claimed
+		if err := rows.Scan(&c.userId, &c.pairId); err != nil {
+			rows.Close()
+			return err
+		}
+		members = append(members, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		streak, err := CurrentStreak(ctx, db, m.userId)
+		if err != nil {
+			log.Printf("failed to load streak of %d: %v\n", m.userId, err)
+			continue
+		}
+		if streak < minStreakAtRisk {
+			continue
+		}
+		_, err = notifier.Notify(domain.Notification{
+			RecipientID: m.userId,
+			Type:        domain.NotificationStreak,
+			Message:     fmt.Sprintf("🔥 Your %d-day streak is at risk! Confirm today's conversation to keep it going.", streak),
+			Payload:     map[string]interface{}{"pairId": m.pairId, "streak": streak},
+		})
+		if err != nil {
+			log.Printf("failed to send streak warning to %d: %v\n", m.userId, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"lingo-backend/domain"
+	"log"
+	"os"
+	"time"
+)
+
+// MatchRateWindow is how far back matches are counted when estimating waits
+const MatchRateWindow = time.Hour
+
+// WaitlistTimeout is how long a user stays on the waitlist before giving up,
+// configured with WAITLIST_TIMEOUT.
+func WaitlistTimeout() time.Duration {
+	d, err := time.ParseDuration(os.Getenv("WAITLIST_TIMEOUT"))
+	if err != nil || d <= 0 {
+		return 30 * time.Minute
+	}
+	return d
+}
+
+// ExpireWaitlist removes users who have waited longer than the timeout and
+// lets them know nobody was found.
+func ExpireWaitlist(ctx context.Context, db *sql.DB, events domain.EventPublisher, notifier domain.Notifier) error {
+	rows, err := db.QueryContext(ctx, `
+		DELETE FROM waitlist
+		WHERE createdAt < NOW() - make_interval(secs => $1)
+		RETURNING userid`, WaitlistTimeout().Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to expire waitlist: %w", err)
+	}
+	var expired []int64
+	for rows.Next() {
+		var userId int64
+		if err := rows.Scan(&userId); err != nil {
+			rows.Close()
+			return err
+		}
+		expired = append(expired, userId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userId := range expired {
+		_, err := notifier.Notify(domain.Notification{
+			RecipientID: userId,
+			Type:        domain.NotificationWaitlistExpired,
+			Message:     "We couldn't find you a partner this time. Please try again later!",
+			Payload:     map[string]interface{}{"expired": true},
+		})
+		if err != nil {
+			log.Printf("failed to notify user %d about waitlist expiry: %v\n", userId, err)
+		}
+		events.Publish(userId, domain.Event{Type: domain.EventWaitlistStatus, Payload: map[string]bool{"waiting": false, "expired": true}})
+	}
+	if len(expired) > 0 {
+		log.Printf("⌛ %d user(s) removed from the waitlist\n", len(expired))
+	}
+
+	// Old matches don't say anything about the current rate
+	_, err = db.ExecContext(ctx, `DELETE FROM match_log WHERE matched_at < NOW() - INTERVAL '7 days'`)
+	return err
+}
@@ -14,6 +14,15 @@ func NewOtpUsecase(otpRepo domain.OtpRepository) *OtpUsecase {
 func (u *OtpUsecase) SaveOtp(otp domain.Otp) error {
 	return u.otpRepo.SaveOtp(otp)
 }
-func (u *OtpUsecase) CheckOtp(username string, otp int64) (*domain.User, error) {
-	return u.otpRepo.CheckOtp(username, otp)
+func (u *OtpUsecase) CheckOtp(userId int64, username string, otp int64) (*domain.User, error) {
+	return u.otpRepo.CheckOtp(userId, username, otp)
+}
+func (u *OtpUsecase) ExchangeLoginToken(token string) (*domain.User, error) {
+	return u.otpRepo.ExchangeLoginToken(token)
+}
+func (u *OtpUsecase) CreateSession(userId int64) (string, error) {
+	return u.otpRepo.CreateSession(userId)
+}
+func (u *OtpUsecase) ValidateSession(token string) (int64, error) {
+	return u.otpRepo.ValidateSession(token)
 }
@@ -1,6 +1,9 @@
 package usecase
 
-import domain "lingo-backend/domain"
+import (
+	domain "lingo-backend/domain"
+	"time"
+)
 
 type PairUsecase struct {
 	repository domain.PairRepository
@@ -18,3 +21,23 @@ func (u *PairUsecase) GetDailyPairs(userId int64) (domain.Pair, error) {
 func (u *PairUsecase) UpdatePairParticipation(pairId string, userId int64, participating bool) error {
 	return u.repository.UpdatePairParticipation(pairId, userId, participating)
 }
+
+func (u *PairUsecase) SetParticipationDeadline(date string, deadline time.Time) (int64, error) {
+	return u.repository.SetParticipationDeadline(date, deadline)
+}
+
+func (u *PairUsecase) ConfirmSession(pairId string, reporterId int64, reports []domain.SessionConfirmation) error {
+	return u.repository.ConfirmSession(pairId, reporterId, reports)
+}
+
+func (u *PairUsecase) GetAnnouncementGroup() (domain.AnnouncementGroup, error) {
+	return u.repository.GetAnnouncementGroup()
+}
+
+func (u *PairUsecase) SetAnnouncementGroup(chatId int64, title string) (domain.AnnouncementGroup, error) {
+	return u.repository.SetAnnouncementGroup(chatId, title)
+}
+
+func (u *PairUsecase) ClearAnnouncementGroup() error {
+	return u.repository.ClearAnnouncementGroup()
+}
@@ -15,25 +15,89 @@ func NewUserUsecase(userRepo domain.UserRepository) *UserUsecase {
 	}
 }
 
-func (u *UserUsecase) FillAttendance(userIds []int64) error {
-	return u.userRepo.FillAttendance(userIds)
+func (u *UserUsecase) FillAttendance(pairId string, userIds []int64) error {
+	return u.userRepo.FillAttendance(pairId, userIds)
 }
 
-func (u *UserUsecase) MissAttendance(userId int64) error {
-	return u.userRepo.MissAttendance(userId)
+func (u *UserUsecase) MissAttendance(pairId string, userId int64) error {
+	return u.userRepo.MissAttendance(pairId, userId)
 }
 
-func (u *UserUsecase) PairUser(userId int64, username string, profileUrl string) (util.PairResponse, error) {
-	return u.userRepo.PairUser(userId, username, profileUrl)
+func (u *UserUsecase) PairUser(userId int64, username string, profileUrl string, prefs domain.MatchPreferences) (util.PairResponse, error) {
+	return u.userRepo.PairUser(userId, username, profileUrl, prefs)
 }
 
-func (u *UserUsecase) GetNotifications(userId int64) (domain.NotificationResponse, error) {
-	return u.userRepo.GetNotifications(userId)
+func (u *UserUsecase) MatchWaitingUsers() (int, error) {
+	return u.userRepo.MatchWaitingUsers()
+}
+
+func (u *UserUsecase) CancelPairRequest(userId int64) error {
+	return u.userRepo.CancelPairRequest(userId)
+}
+
+func (u *UserUsecase) GetWaitlistStatus(userId int64) (domain.WaitlistStatus, error) {
+	return u.userRepo.GetWaitlistStatus(userId)
+}
+
+func (u *UserUsecase) GetNotifications(userId int64, cursor int64, limit int) (domain.NotificationResponse, error) {
+	return u.userRepo.GetNotifications(userId, cursor, limit)
+}
+
+func (u *UserUsecase) GetUnreadCount(userId int64) (int64, error) {
+	return u.userRepo.GetUnreadCount(userId)
+}
+
+func (u *UserUsecase) GetEvents(userId int64, afterId int64) ([]domain.Event, error) {
+	return u.userRepo.GetEvents(userId, afterId)
 }
 
 func (u *UserUsecase) SeenNotification(userId int64) error {
 	return u.userRepo.SeenNotification(userId)
 }
+
+func (u *UserUsecase) MarkNotificationRead(userId int64, notificationId int64) error {
+	return u.userRepo.MarkNotificationRead(userId, notificationId)
+}
+
+func (u *UserUsecase) MarkNotificationsReadBefore(userId int64, before int64) (int64, error) {
+	return u.userRepo.MarkNotificationsReadBefore(userId, before)
+}
+
+func (u *UserUsecase) GetNotificationSettings(userId int64) (domain.NotificationSettings, error) {
+	return u.userRepo.GetNotificationSettings(userId)
+}
+
+func (u *UserUsecase) UpdateNotificationSettings(userId int64, settings domain.NotificationSettings) (domain.NotificationSettings, error) {
+	return u.userRepo.UpdateNotificationSettings(userId, settings)
+}
+
+func (u *UserUsecase) ResetNotificationSettings(userId int64) (domain.NotificationSettings, error) {
+	return u.userRepo.ResetNotificationSettings(userId)
+}
+
+func (u *UserUsecase) GetNotificationPreferences(userId int64) ([]domain.NotificationPreference, error) {
+	return u.userRepo.GetNotificationPreferences(userId)
+}
+
+func (u *UserUsecase) UpdateNotificationPreferences(userId int64, prefs []domain.NotificationPreference) ([]domain.NotificationPreference, error) {
+	return u.userRepo.UpdateNotificationPreferences(userId, prefs)
+}
+
+func (u *UserUsecase) BroadcastNotification(message string, payload map[string]interface{}) (int, int, error) {
+	return u.userRepo.BroadcastNotification(message, payload)
+}
 func (u *UserUsecase) GeneratePair() (string, error) {
 	return u.userRepo.GeneratePair()
 }
+
+func (u *UserUsecase) RecalculateAttendance(apply bool) ([]domain.AttendanceRecalculation, error) {
+	return u.userRepo.RecalculateAttendance(apply)
+}
+
+func (u *UserUsecase) GetDisputes(status string) ([]domain.AttendanceDispute, error) {
+	return u.userRepo.GetDisputes(status)
+}
+
+func (u *UserUsecase) ResolveDispute(disputeId int64, attended bool) error {
+	return u.userRepo.ResolveDispute(disputeId, attended)
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"os"
+	"time"
+)
+
+// DefaultTimezone is used for users who haven't told us where they are.
+// It can be overridden with the DEFAULT_TIMEZONE env var.
+func DefaultTimezone() string {
+	if tz := os.Getenv("DEFAULT_TIMEZONE"); tz != "" {
+		if _, err := time.LoadLocation(tz); err == nil {
+			return tz
+		}
+	}
+	return "UTC"
+}
+
+// ResolveTimezone returns tz when it is a valid IANA name and the default otherwise.
+func ResolveTimezone(tz string) string {
+	if tz == "" {
+		return DefaultTimezone()
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return DefaultTimezone()
+	}
+	return tz
+}
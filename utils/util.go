@@ -23,3 +23,11 @@ func WriteError(w http.ResponseWriter, err error, status int) {
 type PairResponse struct {
 	Wait bool `json:"wait"`
 }
+
+// Mention is how a user is shown in messages, Telegram usernames are optional
+func Mention(username string) string {
+	if username == "" {
+		return "your partner"
+	}
+	return "@" + username
+}